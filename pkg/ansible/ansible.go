@@ -0,0 +1,159 @@
+package ansible
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/foxdalas/nodeup/pkg/nodeup_const"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var _ nodeup.Bootstrap = &Ansible{}
+var _ nodeup.Runner = &Ansible{}
+
+// New returns a bootstrap provider running playbook from the build machine
+// against the new host over the nodeup SSH settings
+func New(nodeup nodeup.NodeUP, playbook string, user string, args string, ssh SSH) (*Ansible, error) {
+	_, err := os.Stat(playbook)
+	if err != nil {
+		return nil, err
+	}
+	path, err := exec.LookPath("ansible-playbook")
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Ansible{
+		nodeup:   nodeup,
+		command:  path,
+		playbook: playbook,
+		user:     user,
+		args:     strings.Fields(args),
+		ssh:      ssh,
+	}
+	return a, nil
+}
+
+func (a *Ansible) Prepare(target *nodeup.Target) (map[string][]byte, error) {
+	return make(map[string][]byte), nil
+}
+
+func (a *Ansible) Commands(target *nodeup.Target) []string {
+	return nil
+}
+
+// Run writes a temporary inventory with the host and runs ansible-playbook.
+// Output goes to the host log.
+func (a *Ansible) Run(target *nodeup.Target) error {
+	if target.Address == "" {
+		return errors.New("No SSH address for ansible")
+	}
+
+	dir, err := ioutil.TempDir("", "nodeup-ansible")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	inventory, err := a.createInventory(target)
+	if err != nil {
+		return err
+	}
+	inventoryPath := filepath.Join(dir, "inventory.json")
+	err = ioutil.WriteFile(inventoryPath, inventory, 0600)
+	if err != nil {
+		return err
+	}
+
+	args := append([]string{"-i", inventoryPath}, a.args...)
+	args = append(args, a.playbook)
+
+	cmd := exec.Command(a.command, args...)
+	if a.ssh.KnownHosts == "" {
+		cmd.Env = append(os.Environ(), "ANSIBLE_HOST_KEY_CHECKING=False")
+	}
+	cmd.Stdout = target.Log
+	cmd.Stderr = target.Log
+
+	a.Log().Infof("Running ansible-playbook %s for host %s", a.playbook, target.Hostname)
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("ansible-playbook %s: %s", a.playbook, err)
+	}
+	a.Log().Debugf("ansible-playbook %s finished for host %s", a.playbook, target.Hostname)
+	return nil
+}
+
+func (a *Ansible) Verify(target *nodeup.Target) error {
+	return nil
+}
+
+func (a *Ansible) Cleanup(target *nodeup.Target) error {
+	return nil
+}
+
+func (a *Ansible) createInventory(target *nodeup.Target) ([]byte, error) {
+	sshArgs, err := a.sshArgs(target)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := map[string]interface{}{
+		"ansible_host":       target.Address,
+		"ansible_user":       a.user,
+		"nodeup_hostname":    target.Hostname,
+		"nodeup_domain":      target.Domain,
+		"nodeup_server_id":   target.ServerID,
+		"nodeup_addresses":   target.Addresses,
+		"nodeup_environment": target.Environment,
+		"openstack_metadata": target.Metadata,
+	}
+	if len(sshArgs) > 0 {
+		vars["ansible_ssh_common_args"] = strings.Join(sshArgs, " ")
+	}
+	if a.ssh.Key != "" {
+		vars["ansible_ssh_private_key_file"] = a.ssh.Key
+	}
+
+	inventory := map[string]interface{}{
+		"all": map[string]interface{}{
+			"hosts": map[string]interface{}{
+				target.Hostname: vars,
+			},
+		},
+	}
+	return json.MarshalIndent(inventory, "", "  ")
+}
+
+// sshArgs returns the ssh options for the known_hosts file of nodeup, the
+// certificate and the jump hosts of the environment
+func (a *Ansible) sshArgs(target *nodeup.Target) ([]string, error) {
+	var args []string
+	if a.ssh.KnownHosts != "" {
+		args = append(args, "-o StrictHostKeyChecking=yes", "-o UserKnownHostsFile="+quote(a.ssh.KnownHosts))
+	} else {
+		args = append(args, "-o StrictHostKeyChecking=no", "-o UserKnownHostsFile=/dev/null")
+	}
+	if a.ssh.Cert != "" {
+		args = append(args, "-o CertificateFile="+quote(a.ssh.Cert))
+	}
+	if a.ssh.ProxyJump != nil {
+		jump, err := a.ssh.ProxyJump(target.Environment)
+		if err != nil {
+			return nil, err
+		}
+		if jump != "" {
+			args = append(args, "-o ProxyJump="+quote(jump))
+		}
+	}
+	return args, nil
+}
+
+// quote quotes s for the shell-like splitting of ansible_ssh_common_args
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
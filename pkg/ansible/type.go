@@ -0,0 +1,26 @@
+package ansible
+
+import (
+	"github.com/foxdalas/nodeup/pkg/nodeup_const"
+	"github.com/sirupsen/logrus"
+)
+
+type Ansible struct {
+	nodeup   nodeup.NodeUP
+	command  string
+	playbook string
+	user     string
+	args     []string
+	ssh      SSH
+
+	log *logrus.Entry
+}
+
+// SSH are the nodeup SSH settings used by ansible-playbook. Without
+// KnownHosts host keys are not checked.
+type SSH struct {
+	Key        string
+	Cert       string
+	KnownHosts string
+	ProxyJump  func(environment string) (string, error)
+}
@@ -0,0 +1,8 @@
+package ansible
+
+import "github.com/sirupsen/logrus"
+
+func (a *Ansible) Log() *logrus.Entry {
+	log := a.nodeup.Log().WithField("context", "ansible")
+	return log
+}
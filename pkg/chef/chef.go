@@ -3,25 +3,33 @@ package chef
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"github.com/foxdalas/nodeup/pkg/nodeup_const"
 	"github.com/go-chef/chef"
 	"github.com/sirupsen/logrus"
+	"strings"
 	"text/template"
 )
 
-func New(nodeup nodeup.NodeUP, nodeName string, nodeDomain, chefServerUrl string, validationData []byte, chefValidationPath string, runlist []string) (chef *Chef, err error) {
+func New(nodeup nodeup.NodeUP, nodeName string, chefServerUrl string, validationData []byte, chefValidationPath string, runlist []string, attributes map[string]interface{}, policyName string, policyGroup string, uploadDir string) (chef *Chef, err error) {
 
-	chefConfig, err := createConfig(nodeName, ":auto", "STDOUT", chefServerUrl, "chef-validator")
-	if err != nil {
-		return nil, err
+	// Without validation key the node authenticates with a pre-created client key
+	validationClientName := validatorName
+	if validationData == nil {
+		validationClientName = ""
 	}
 
-	hosts, err := createHostFile(nodeName, nodeDomain)
+	chefConfig, err := createConfig(nodeName, ":auto", "STDOUT", chefServerUrl, validationClientName, uploadDir+"/validation.pem", policyName, policyGroup)
 	if err != nil {
 		return nil, err
 	}
 
-	bootstapJson, err := createBootstrapJson(runlist)
+	// Policyfile nodes take the run list from the policy
+	if policyName != "" {
+		runlist = nil
+	}
+
+	bootstapJson, err := createBootstrapJson(runlist, attributes)
 	if err != nil {
 		return
 	}
@@ -31,18 +39,20 @@ func New(nodeup nodeup.NodeUP, nodeName string, nodeDomain, chefServerUrl string
 		ChefConfig:    chefConfig,
 		BootstrapJson: bootstapJson,
 		ValidationPem: validationData,
-		Hosts:         hosts,
 	}
 	return
 }
 
-func createConfig(nodeName string, logLevel string, logLocation string, chefServerUrl string, validationClientName string) ([]byte, error) {
+func createConfig(nodeName string, logLevel string, logLocation string, chefServerUrl string, validationClientName string, validationKey string, policyName string, policyGroup string) ([]byte, error) {
 	config := &Config{
 		LogLevel:             logLevel,
 		LogLocation:          logLocation,
 		ChefServerUrl:        chefServerUrl,
 		ValidationClientName: validationClientName,
+		ValidationKey:        validationKey,
 		NodeName:             nodeName,
+		PolicyName:           policyName,
+		PolicyGroup:          policyGroup,
 	}
 
 	var buf bytes.Buffer
@@ -50,10 +60,12 @@ func createConfig(nodeName string, logLevel string, logLocation string, chefServ
 	t, err := t.Parse(`
 log_level        {{ .LogLevel }}
 log_location     {{ .LogLocation }}
-chef_server_url  "{{ .ChefServerUrl }}"
-validation_client_name "{{ .ValidationClientName }}"
-node_name "{{ .NodeName }}"
-validation_key "/home/cloud-user/validation.pem"`)
+chef_server_url  "{{ .ChefServerUrl }}"{{ if .ValidationClientName }}
+validation_client_name "{{ .ValidationClientName }}"{{ end }}
+node_name "{{ .NodeName }}"{{ if .ValidationClientName }}
+validation_key "{{ .ValidationKey }}"{{ end }}{{ if .PolicyName }}
+policy_name "{{ .PolicyName }}"
+policy_group "{{ .PolicyGroup }}"{{ end }}`)
 	if err != nil {
 		return nil, err
 	}
@@ -64,29 +76,18 @@ validation_key "/home/cloud-user/validation.pem"`)
 	return buf.Bytes(), nil
 }
 
-func createHostFile(nodeName string, domainName string) ([]byte, error) {
-	hosts := &Hosts{
-		Hostname: nodeName,
-		Domain:   domainName,
-	}
-
-	var buf bytes.Buffer
-	t := template.New("hosts")
-	t, err := t.Parse(`
-127.0.0.1       localhost
-127.0.1.1       {{ .Hostname }}.{{ .Domain }} {{ .Hostname }}`)
-	if err != nil {
-		return nil, err
+// createBootstrapJson renders the first-boot JSON: node attributes with the
+// run list. The run list is left out when it is nil (Policyfile nodes).
+func createBootstrapJson(runlist []string, attributes map[string]interface{}) (j []byte, err error) {
+	data := make(map[string]interface{})
+	for key, value := range attributes {
+		data[key] = value
 	}
-	err = t.Execute(&buf, hosts)
-	if err != nil {
-		return nil, err
+	if runlist != nil {
+		data["run_list"] = runlist
 	}
-	return buf.Bytes(), nil
-}
 
-func createBootstrapJson(runlist []string) (j []byte, err error) {
-	j, err = json.Marshal(Bootstrap{runlist})
+	j, err = json.Marshal(data)
 	if err != nil {
 		return
 	}
@@ -112,6 +113,7 @@ func NewChefClient(nodeup nodeup.NodeUP, clientName string, key []byte, serverUR
 	c := &ChefClient{
 		nodeup: nodeup,
 		client: client,
+		name:   clientName,
 	}
 
 	return c, nil
@@ -137,37 +139,39 @@ func (c *ChefClient) deleteChefClient(clientName string) (err error) {
 	return
 }
 
+// CleanupNode deletes the client and the node, each one if it exists. The
+// node may be pre-created before the client is.
 func (c *ChefClient) CleanupNode(nodeName string, clientName string) (status bool, err error) {
-	if c.isClientExist(clientName) {
+	if c.IsClientExist(clientName) {
 		err = c.deleteChefClient(clientName)
 		if err != nil {
 			c.Log().Error(err)
 			status = false
 			return
 		}
-		err = c.deleteChefNode(clientName)
+	}
+	if c.IsNodeExist(nodeName) {
+		err = c.deleteChefNode(nodeName)
 		if err != nil {
 			c.Log().Error(err)
 			status = false
 			return
 		}
 	}
+	status = true
 	return
 }
 
-func (c *ChefClient) isNodeExist(nodeName string) bool {
-	if c.isNodeExist(nodeName) {
-		_, err := c.client.Nodes.Get(nodeName)
-		if err != nil {
-			return false
-		} else {
-			return true
-		}
+func (c *ChefClient) IsNodeExist(nodeName string) bool {
+	_, err := c.client.Nodes.Get(nodeName)
+	if err != nil {
+		return false
+	} else {
+		return true
 	}
-	return true
 }
 
-func (c *ChefClient) isClientExist(clientName string) bool {
+func (c *ChefClient) IsClientExist(clientName string) bool {
 	_, err := c.client.Clients.Get(clientName)
 	if err != nil {
 		return false
@@ -175,3 +179,99 @@ func (c *ChefClient) isClientExist(clientName string) bool {
 		return true
 	}
 }
+
+// CheckPolicy returns an error if the policy group doesn't exist on the Chef
+// server or has no revision of the policy
+func (c *ChefClient) CheckPolicy(policyName string, policyGroup string) error {
+	req, err := c.client.NewRequest("GET", "policy_groups/"+policyGroup, nil)
+	if err != nil {
+		return err
+	}
+
+	group := &PolicyGroup{}
+	_, err = c.client.Do(req, group)
+	if err != nil {
+		return fmt.Errorf("Policy group %s: %s", policyGroup, err)
+	}
+	if _, ok := group.Policies[policyName]; !ok {
+		return fmt.Errorf("Policy %s is not pushed to policy group %s", policyName, policyGroup)
+	}
+	return nil
+}
+
+// CreateClient registers a new API client and returns its private key
+func (c *ChefClient) CreateClient(clientName string) ([]byte, error) {
+	c.Log().Infof("Creating chef client %s", clientName)
+	body, err := chef.JSONReader(map[string]interface{}{
+		"name":       clientName,
+		"create_key": true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := c.client.NewRequest("POST", "clients", body)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ClientKey{}
+	_, err = c.client.Do(req, result)
+	if err != nil {
+		return nil, fmt.Errorf("Create chef client %s: %s", clientName, err)
+	}
+
+	// API v0 returns the key as private_key, v1 inside chef_key
+	key := result.PrivateKey
+	if key == "" {
+		key = result.ChefKey.PrivateKey
+	}
+	if key == "" {
+		return nil, fmt.Errorf("Create chef client %s: no private key in response", clientName)
+	}
+	return []byte(key), nil
+}
+
+// CreateNode saves a new node object on the Chef server
+func (c *ChefClient) CreateNode(node chef.Node) error {
+	c.Log().Infof("Creating chef node %s", node.Name)
+	_, err := c.client.Nodes.Post(node)
+	if err != nil {
+		return fmt.Errorf("Create chef node %s: %s", node.Name, err)
+	}
+	return nil
+}
+
+// GrantNode sets the actors and groups allowed to update the node
+func (c *ChefClient) GrantNode(nodeName string, actors []string, groups []string) error {
+	return c.grant("nodes", nodeName, actors, groups)
+}
+
+// GrantClient sets the actors and groups allowed to update the client
+func (c *ChefClient) GrantClient(clientName string, actors []string, groups []string) error {
+	return c.grant("clients", clientName, actors, groups)
+}
+
+// grant replaces the update ACE of a Chef object
+func (c *ChefClient) grant(kind string, name string, actors []string, groups []string) error {
+	c.Log().Debugf("Granting update on chef %s %s to %s and groups %s", kind, name, strings.Join(actors, ", "), strings.Join(groups, ", "))
+	body, err := chef.JSONReader(map[string]interface{}{
+		"update": map[string][]string{
+			"actors": actors,
+			"groups": groups,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := c.client.NewRequest("PUT", kind+"/"+name+"/_acl/update", body)
+	if err != nil {
+		return err
+	}
+	_, err = c.client.Do(req, nil)
+	if err != nil {
+		return fmt.Errorf("Chef %s %s ACL: %s", kind, name, err)
+	}
+	return nil
+}
@@ -2,12 +2,16 @@ package chef
 
 import (
 	"fmt"
+	"github.com/foxdalas/nodeup/pkg/nodeup_const"
+	"github.com/go-chef/chef"
 	"github.com/stretchr/testify/assert"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestCreateConfig(t *testing.T) {
-	r, err := createConfig("test-node", ":auto", "STDOUT", "http://localhost", "chef-validator")
+	r, err := createConfig("test-node", ":auto", "STDOUT", "http://localhost", "chef-validator", "/home/cloud-user/validation.pem", "", "")
 	assert.Equal(t, nil, err)
 	testData := `
 log_level        :auto
@@ -20,19 +24,208 @@ validation_key "/home/cloud-user/validation.pem"`
 	assert.Equal(t, testData, string(r))
 }
 
-func TestCreateHostFile(t *testing.T) {
-	r, err := createHostFile("test", "hostname.example.com")
+func TestCreateConfigPolicy(t *testing.T) {
+	r, err := createConfig("test-node", ":auto", "STDOUT", "http://localhost", "chef-validator", "/home/cloud-user/validation.pem", "app", "production")
 	assert.Equal(t, nil, err)
+	testData := `
+log_level        :auto
+log_location     STDOUT
+chef_server_url  "http://localhost"
+validation_client_name "chef-validator"
+node_name "test-node"
+validation_key "/home/cloud-user/validation.pem"
+policy_name "app"
+policy_group "production"`
+	assert.Equal(t, testData, string(r))
+}
 
+func TestCreateConfigValidatorless(t *testing.T) {
+	r, err := createConfig("test-node", ":auto", "STDOUT", "http://localhost", "", "", "", "")
+	assert.Equal(t, nil, err)
 	testData := `
-127.0.0.1       localhost
-127.0.1.1       test.hostname.example.com test`
+log_level        :auto
+log_location     STDOUT
+chef_server_url  "http://localhost"
+node_name "test-node"`
+	assert.Equal(t, testData, string(r))
+}
+
+func TestCreateSoloConfig(t *testing.T) {
+	r, err := createSoloConfig("test-node", ":auto", "STDOUT", "/var/chef/local")
+	assert.Equal(t, nil, err)
+	testData := `
+log_level        :auto
+log_location     STDOUT
+local_mode       true
+chef_repo_path   "/var/chef/local"
+node_name "test-node"`
 	assert.Equal(t, testData, string(r))
 }
 
 func TestCreateBootstrapJson(t *testing.T) {
-	r, err := createBootstrapJson([]string{"role[test]"})
+	r, err := createBootstrapJson([]string{"role[test]"}, nil)
 	assert.Equal(t, nil, err)
 	testData := `{"run_list":["role[test]"]}`
 	assert.Equal(t, testData, string(r))
 }
+
+func TestCreateBootstrapJsonAttributes(t *testing.T) {
+	attributes := map[string]interface{}{
+		"mongodb": map[string]interface{}{
+			"shard": 3,
+		},
+		"run_list": []string{"role[ignored]"},
+	}
+	r, err := createBootstrapJson([]string{"role[base]", "recipe[mongodb::shard]"}, attributes)
+	assert.Equal(t, nil, err)
+	testData := `{"mongodb":{"shard":3},"run_list":["role[base]","recipe[mongodb::shard]"]}`
+	assert.Equal(t, testData, string(r))
+}
+
+func TestNewNode(t *testing.T) {
+	target := &nodeup.Target{
+		Hostname:    "test-node",
+		Environment: "production",
+		RunList:     []string{"role[base]"},
+		Attributes: map[string]interface{}{
+			"tags":    []interface{}{"mongodb"},
+			"mongodb": map[string]interface{}{"shard": 3},
+		},
+		Tags: []string{"nodeup", "nodeup:creator=test"},
+	}
+
+	node := newNode(target)
+	assert.Equal(t, "test-node", node.Name)
+	assert.Equal(t, "production", node.Environment)
+	assert.Equal(t, []string{"role[base]"}, node.RunList)
+	assert.Equal(t, []interface{}{"mongodb", "nodeup", "nodeup:creator=test"}, node.NormalAttributes["tags"])
+	assert.Equal(t, map[string]interface{}{"shard": 3}, node.NormalAttributes["mongodb"])
+	assert.Equal(t, []interface{}{"mongodb"}, target.Attributes["tags"])
+
+	target.PolicyName = "app"
+	target.PolicyGroup = "production"
+	node = newNode(target)
+	assert.Equal(t, 0, len(node.RunList))
+	assert.Equal(t, "app", node.PolicyName)
+}
+
+func TestCheckNode(t *testing.T) {
+	started := time.Unix(1500000000, 0)
+	target := &nodeup.Target{
+		Hostname:        "test-node",
+		Addresses:       []string{"203.0.113.5"},
+		ServerAddresses: []string{"10.0.0.5", "192.168.0.5", "203.0.113.5"},
+		Environment:     "production",
+		RunList:         []string{"role[base]", "nginx"},
+	}
+	node := chef.Node{
+		Name:        "test-node",
+		Environment: "production",
+		RunList:     []string{"role[base]", "recipe[nginx]"},
+		AutomaticAttributes: map[string]interface{}{
+			"ohai_time": float64(1500000100),
+			"ipaddress": "192.168.0.5",
+		},
+	}
+	assert.Equal(t, nil, checkNode(node, target, started))
+
+	node.AutomaticAttributes["ohai_time"] = float64(1400000000)
+	assert.NotNil(t, checkNode(node, target, started))
+	node.AutomaticAttributes["ohai_time"] = float64(1500000100)
+
+	node.Environment = "_default"
+	assert.NotNil(t, checkNode(node, target, started))
+	node.Environment = "production"
+
+	node.RunList = []string{"role[base]"}
+	assert.NotNil(t, checkNode(node, target, started))
+	node.RunList = []string{"role[base]", "recipe[nginx]"}
+
+	// floating IP server, ohai sees the fixed private address
+	node.AutomaticAttributes["ipaddress"] = "10.0.0.5"
+	assert.Equal(t, nil, checkNode(node, target, started))
+
+	node.AutomaticAttributes["ipaddress"] = "10.0.0.6"
+	assert.NotNil(t, checkNode(node, target, started))
+}
+
+func TestParseRunDoc(t *testing.T) {
+	log := `Starting Chef Client, version 12.20.3
+resolving cookbooks for run list: ["nginx"]
+Converging 3 resources
+Recipe: nginx::default
+  * apt_package[nginx] action install
+
+    ================================================================================
+    Error executing action ` + "`install`" + ` on resource 'apt_package[nginx]'
+    ================================================================================
+
+    Mixlib::ShellOut::ShellCommandFailed
+    ------------------------------------
+    Expected process to exit with [0], but received '100'
+    ---- Begin output of apt-get -q -y install nginx ----
+
+    Resource Declaration:
+    ---------------------
+
+Running handlers complete
+Chef Client failed. 1 resources updated in 12 seconds
+[2018-01-01T00:00:00+00:00] FATAL: Chef::Exceptions::ChildConvergeError: Chef run process exited unsuccessfully (exit code 1)
+`
+	summary := parseRun(strings.NewReader(log))
+	assert.Equal(t, false, summary.Finished)
+	assert.Equal(t, 1, summary.Updated)
+	assert.Equal(t, "12 seconds", summary.Elapsed)
+	assert.Equal(t, "apt_package[nginx]", summary.Resource)
+	assert.Equal(t, "Mixlib::ShellOut::ShellCommandFailed", summary.Exception)
+	assert.Equal(t, "Expected process to exit with [0], but received '100'", summary.Message)
+
+	log += `Starting Chef Client, version 12.20.3
+Chef Client finished, 5/45 resources updated in 01 minutes 03 seconds
+`
+	summary = parseRun(strings.NewReader(log))
+	assert.Equal(t, &nodeup.Summary{Finished: true, Updated: 5, Total: 45, Elapsed: "01 minutes 03 seconds"}, summary)
+}
+
+func TestParseRunLog(t *testing.T) {
+	log := `[2018-01-01T00:00:00+00:00] INFO: *** Chef 12.20.3 ***
+[2018-01-01T00:00:05+00:00] ERROR: apt_package[nginx] (nginx::default line 3) had an error: Mixlib::ShellOut::ShellCommandFailed: Expected process to exit with [0], but received '100'
+[2018-01-01T00:00:06+00:00] FATAL: Chef::Exceptions::ChildConvergeError: Chef run process exited unsuccessfully (exit code 1)
+`
+	summary := parseRun(strings.NewReader(log))
+	assert.Equal(t, false, summary.Finished)
+	assert.Equal(t, "apt_package[nginx]", summary.Resource)
+	assert.Equal(t, "Mixlib::ShellOut::ShellCommandFailed", summary.Exception)
+
+	log = `[2018-01-01T00:00:00+00:00] INFO: *** Chef 12.20.3 ***
+[2018-01-01T00:00:30+00:00] INFO: Chef Run complete in 30.5 seconds
+[2018-01-01T00:00:30+00:00] INFO: 3/10 resources updated
+`
+	summary = parseRun(strings.NewReader(log))
+	assert.Equal(t, &nodeup.Summary{Finished: true, Updated: 3, Total: 10, Elapsed: "30.5 seconds"}, summary)
+
+	assert.Nil(t, parseRun(strings.NewReader("apt-get update\n")))
+}
+
+func TestInstaller(t *testing.T) {
+	i, err := NewInstaller("", "", "12.20.3")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "if chef-client --version 2>/dev/null | grep -q ': 12.20.3$'; then echo chef-client 12.20.3 is already installed; else "+
+		"wget -q https://omnitruck.chef.io/install.sh && sudo bash ./install.sh -v 12.20.3 && rm install.sh; fi", i.Command("debian", "/home/cloud-user"))
+	assert.Equal(t, 0, len(i.Files()))
+
+	i, err = NewInstaller("repo", "", "12.20.3")
+	assert.Equal(t, nil, err)
+	assert.Contains(t, i.Command("rhel", "/home/cloud-user"), "else sudo yum install -y chef-12.20.3; fi")
+
+	_, err = NewInstaller("repo", "abc", "12.20.3")
+	assert.NotNil(t, err)
+
+	i, err = NewInstaller("https://mirror/chef_12.20.3-1_amd64.deb", "ABC", "12.20.3")
+	assert.Equal(t, nil, err)
+	assert.Contains(t, i.Command("debian", "/home/cloud-user"), "else wget -q -O /home/cloud-user/chef_12.20.3-1_amd64.deb https://mirror/chef_12.20.3-1_amd64.deb && "+
+		"echo 'abc  /home/cloud-user/chef_12.20.3-1_amd64.deb' | sha256sum -c - && sudo dpkg -i /home/cloud-user/chef_12.20.3-1_amd64.deb && rm /home/cloud-user/chef_12.20.3-1_amd64.deb; fi")
+
+	_, err = NewInstaller("https://mirror/chef.tar.gz", "", "12.20.3")
+	assert.NotNil(t, err)
+}
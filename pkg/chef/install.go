@@ -0,0 +1,110 @@
+package chef
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// NewInstaller returns the chef-client installer for source:
+//
+//	""                    omnitruck install.sh
+//	"repo"                chef package from the apt/yum/zypper repos of the image
+//	"http(s)://.../x.deb" package downloaded from a mirror
+//	"/path/x.rpm"         local package uploaded with the bootstrap files
+//
+// checksum is the package sha256. It is computed for local packages when empty.
+func NewInstaller(source string, checksum string, version string) (*Installer, error) {
+	i := &Installer{
+		source:   source,
+		checksum: strings.ToLower(checksum),
+		version:  version,
+	}
+
+	switch {
+	case source == "" || source == "repo":
+		if checksum != "" {
+			return nil, fmt.Errorf("Package checksum can't be checked for chef package source %q", source)
+		}
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		i.pkg = path.Base(source)
+	default:
+		data, err := ioutil.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		if i.checksum == "" {
+			i.checksum = hex.EncodeToString(sum[:])
+		} else if i.checksum != hex.EncodeToString(sum[:]) {
+			return nil, fmt.Errorf("Package %s checksum mismatch", source)
+		}
+		i.pkg = path.Base(source)
+		i.data = data
+	}
+
+	if i.pkg != "" && !strings.HasSuffix(i.pkg, ".deb") && !strings.HasSuffix(i.pkg, ".rpm") {
+		return nil, fmt.Errorf("Chef package %s is not a .deb or .rpm", i.pkg)
+	}
+	return i, nil
+}
+
+// Files returns the package to upload for local package sources
+func (i *Installer) Files() map[string][]byte {
+	files := make(map[string][]byte)
+	if i.data != nil {
+		files[i.pkg] = i.data
+	}
+	return files
+}
+
+// Command installs chef-client unless the requested version is already there
+func (i *Installer) Command(family string, dir string) string {
+	return "if chef-client --version 2>/dev/null | grep -q ': " + i.version + "$'; then " +
+		"echo chef-client " + i.version + " is already installed; else " +
+		i.install(family, dir) + "; fi"
+}
+
+func (i *Installer) install(family string, dir string) string {
+	download := "curl -fsSLO"
+	if family == "debian" {
+		download = "wget -q"
+	}
+
+	switch {
+	case i.source == "":
+		return download + " https://omnitruck.chef.io/install.sh && sudo bash ./install.sh -v " + i.version + " && rm install.sh"
+	case i.source == "repo":
+		switch family {
+		case "debian":
+			return "sudo apt-get install -y chef=" + i.version + "-1"
+		case "suse":
+			return "sudo zypper --non-interactive install chef-" + i.version
+		default:
+			return "sudo yum install -y chef-" + i.version
+		}
+	}
+
+	pkg := dir + "/" + i.pkg
+	var command []string
+	if i.data == nil {
+		if family == "debian" {
+			command = append(command, "wget -q -O "+pkg+" "+i.source)
+		} else {
+			command = append(command, "curl -fsSL -o "+pkg+" "+i.source)
+		}
+	}
+	if i.checksum != "" {
+		command = append(command, "echo '"+i.checksum+"  "+pkg+"' | sha256sum -c -")
+	}
+	if strings.HasSuffix(i.pkg, ".deb") {
+		command = append(command, "sudo dpkg -i "+pkg)
+	} else {
+		command = append(command, "sudo rpm -Uvh "+pkg)
+	}
+	command = append(command, "rm "+pkg)
+	return strings.Join(command, " && ")
+}
@@ -0,0 +1,148 @@
+package chef
+
+import (
+	"github.com/foxdalas/nodeup/pkg/nodeup_const"
+	"github.com/go-chef/chef"
+)
+
+var _ nodeup.Bootstrap = &Provider{}
+
+// NewProvider returns a bootstrap provider registering hosts on the Chef
+// server with the validation key. With validatorless the client and node are
+// created through the API instead and only the client key is uploaded.
+func NewProvider(nodeup nodeup.NodeUP, client *ChefClient, serverURL string, validationPem []byte, validationPath string, validatorless bool, installer *Installer) *Provider {
+	return &Provider{
+		nodeup:         nodeup,
+		client:         client,
+		serverURL:      serverURL,
+		validationPem:  validationPem,
+		validationPath: validationPath,
+		validatorless:  validatorless,
+		installer:      installer,
+	}
+}
+
+func (p *Provider) Prepare(target *nodeup.Target) (map[string][]byte, error) {
+	p.setStarted(target.Hostname)
+
+	if p.validatorless {
+		return p.prepareValidatorless(target)
+	}
+
+	chefData, err := New(p.nodeup, target.Hostname, p.serverURL, p.validationPem, p.validationPath, target.RunList, nodeAttributes(target), target.PolicyName, target.PolicyGroup, target.UploadDir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.createNode(target)
+	if err != nil {
+		p.Cleanup(target)
+		return nil, err
+	}
+
+	data := p.installer.Files()
+	data["bootstrap.json"] = chefData.BootstrapJson
+	data["validation.pem"] = chefData.ValidationPem
+	data["client.rb"] = chefData.ChefConfig
+
+	return data, nil
+}
+
+// createNode pre-creates the host's client and its node, which only that
+// client may update. chef-client registers with the validation key, gets a
+// conflict and re-keys the existing client, so the validator may update the
+// client until Verify.
+func (p *Provider) createNode(target *nodeup.Target) error {
+	_, err := p.client.CreateClient(target.Hostname)
+	if err != nil {
+		return err
+	}
+	err = p.client.GrantClient(target.Hostname, []string{p.client.name, validatorName}, []string{"admins"})
+	if err != nil {
+		return err
+	}
+	err = p.client.CreateNode(newNode(target))
+	if err != nil {
+		return err
+	}
+	return p.client.GrantNode(target.Hostname, []string{p.client.name, target.Hostname}, []string{"admins"})
+}
+
+// prepareValidatorless creates the client and, authenticated as that client,
+// the node so the node object is owned by the host itself. The node gets its
+// environment, run list, attributes and tags before the first converge.
+func (p *Provider) prepareValidatorless(target *nodeup.Target) (map[string][]byte, error) {
+	chefData, err := New(p.nodeup, target.Hostname, p.serverURL, nil, "", target.RunList, nodeAttributes(target), target.PolicyName, target.PolicyGroup, target.UploadDir)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := p.client.CreateClient(target.Hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeClient, err := NewChefClient(p.nodeup, target.Hostname, key, p.serverURL)
+	if err != nil {
+		p.Cleanup(target)
+		return nil, err
+	}
+	err = nodeClient.CreateNode(newNode(target))
+	if err != nil {
+		p.Cleanup(target)
+		return nil, err
+	}
+
+	data := p.installer.Files()
+	data["bootstrap.json"] = chefData.BootstrapJson
+	data["client.pem"] = key
+	data["client.rb"] = chefData.ChefConfig
+
+	return data, nil
+}
+
+func (p *Provider) Commands(target *nodeup.Target) []string {
+	dir := target.UploadDir
+	run := "sudo chef-client -c " + dir + "/client.rb -j " + dir + "/bootstrap.json"
+	if target.PolicyName == "" {
+		run += " -E " + target.Environment
+	}
+
+	if p.validatorless {
+		return []string{
+			"sudo mkdir -p /etc/chef",
+			p.installer.Command(target.Family, dir),
+			"sudo mv " + dir + "/client.pem /etc/chef/client.pem && sudo chmod 0600 /etc/chef/client.pem",
+			run,
+			"sudo rm " + dir + "/client.rb && rm " + dir + "/bootstrap.json",
+			"sudo chef-client",
+		}
+	}
+
+	data := []string{
+		"sudo mkdir -p /etc/chef",
+		p.installer.Command(target.Family, dir),
+		run,
+		"sudo rm " + dir + "/client.rb && sudo rm " + dir + "/validation.pem && rm " + dir + "/bootstrap.json",
+		"sudo chef-client",
+	}
+	return data
+}
+
+func (p *Provider) Cleanup(target *nodeup.Target) error {
+	_, err := p.client.CleanupNode(target.Hostname, target.Hostname)
+	return err
+}
+
+func newNode(target *nodeup.Target) chef.Node {
+	node := chef.NewNode(target.Hostname)
+	if target.PolicyName != "" {
+		node.PolicyName = target.PolicyName
+		node.PolicyGroup = target.PolicyGroup
+	} else {
+		node.Environment = target.Environment
+		node.RunList = target.RunList
+	}
+	node.NormalAttributes = nodeAttributes(target)
+	return node
+}
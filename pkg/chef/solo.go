@@ -0,0 +1,96 @@
+package chef
+
+import (
+	"bytes"
+	"github.com/foxdalas/nodeup/pkg/nodeup_const"
+	"io/ioutil"
+	"text/template"
+)
+
+var _ nodeup.Bootstrap = &Solo{}
+
+// NewSolo returns a bootstrap provider running chef-client in local mode with
+// the cookbooks from archive. No Chef server is used.
+func NewSolo(nodeup nodeup.NodeUP, archive string, installer *Installer) (*Solo, error) {
+	data, err := ioutil.ReadFile(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Solo{
+		nodeup:    nodeup,
+		archive:   data,
+		installer: installer,
+	}, nil
+}
+
+func (s *Solo) Prepare(target *nodeup.Target) (map[string][]byte, error) {
+	soloConfig, err := createSoloConfig(target.Hostname, ":auto", "STDOUT", soloRepoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	bootstrapJson, err := createBootstrapJson(target.RunList, target.Attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	data := s.installer.Files()
+	data["solo.rb"] = soloConfig
+	data["bootstrap.json"] = bootstrapJson
+	data[soloArchive] = s.archive
+
+	return data, nil
+}
+
+func (s *Solo) Commands(target *nodeup.Target) []string {
+	dir := target.UploadDir
+	run := "sudo chef-client --local-mode -j /etc/chef/first-boot.json"
+	if target.Environment != "" {
+		run += " -E " + target.Environment
+	}
+
+	data := []string{
+		"sudo mkdir -p /etc/chef " + soloRepoPath,
+		s.installer.Command(target.Family, dir),
+		"sudo tar -xzf " + dir + "/" + soloArchive + " -C " + soloRepoPath + " && rm " + dir + "/" + soloArchive,
+		"sudo mv " + dir + "/solo.rb /etc/chef/client.rb && sudo mv " + dir + "/bootstrap.json /etc/chef/first-boot.json",
+		run,
+	}
+	return data
+}
+
+func (s *Solo) Verify(target *nodeup.Target) error {
+	return nil
+}
+
+// Cleanup does nothing, the node only exists on the host
+func (s *Solo) Cleanup(target *nodeup.Target) error {
+	return nil
+}
+
+func createSoloConfig(nodeName string, logLevel string, logLocation string, repoPath string) ([]byte, error) {
+	config := &SoloConfig{
+		LogLevel:    logLevel,
+		LogLocation: logLocation,
+		NodeName:    nodeName,
+		RepoPath:    repoPath,
+	}
+
+	var buf bytes.Buffer
+	t := template.New("solo.rb")
+	t, err := t.Parse(`
+log_level        {{ .LogLevel }}
+log_location     {{ .LogLocation }}
+local_mode       true
+chef_repo_path   "{{ .RepoPath }}"
+node_name "{{ .NodeName }}"`)
+	if err != nil {
+		return nil, err
+	}
+	err = t.Execute(&buf, config)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,99 @@
+package chef
+
+import (
+	"bufio"
+	"github.com/foxdalas/nodeup/pkg/nodeup_const"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// doc formatter
+	docStarted  = regexp.MustCompile(`^Starting Chef (Infra )?Client`)
+	docFinished = regexp.MustCompile(`(Chef (Infra )?Client|Infra Phase) finished, (\d+)/(\d+) resources updated in (.+)$`)
+	docFailed   = regexp.MustCompile(`(Chef (Infra )?Client|Infra Phase) failed\. (\d+) resources updated in (.+)$`)
+	docResource = regexp.MustCompile("^Error executing action `\\w+` on resource '(.+)'")
+	docRule     = regexp.MustCompile(`^-{4,}$`)
+
+	// log formatter
+	logStarted  = regexp.MustCompile(`INFO: \*\*\* Chef (Infra Client )?[\d.]+ \*\*\*`)
+	logComplete = regexp.MustCompile(`INFO: Chef (Infra Client )?Run complete in ([\d.]+ seconds)`)
+	logUpdated  = regexp.MustCompile(`INFO: (\d+)/(\d+) [Rr]esources updated`)
+	logResource = regexp.MustCompile(`ERROR: (\S+\[.*?\]) \(.*\) had an error: ([\w:]+): (.*)$`)
+	logFatal    = regexp.MustCompile(`FATAL: ([A-Z][\w]*(::\w+)+): (.*)$`)
+)
+
+func (p *Provider) Summarize(log io.Reader) *nodeup.Summary {
+	return parseRun(log)
+}
+
+func (s *Solo) Summarize(log io.Reader) *nodeup.Summary {
+	return parseRun(log)
+}
+
+// parseRun summarizes the last chef-client run found in the log. Output of
+// the doc and the log formatter is understood. nil is returned if the log
+// has no chef-client run.
+func parseRun(r io.Reader) *nodeup.Summary {
+	var summary *nodeup.Summary
+	var previous string
+	exception := false
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if docStarted.MatchString(line) || logStarted.MatchString(line) {
+			summary = &nodeup.Summary{}
+		}
+		if summary == nil {
+			previous = line
+			continue
+		}
+
+		if exception {
+			// the line after the exception class rule is the message
+			if summary.Message == "" {
+				summary.Message = line
+			}
+			exception = false
+		}
+
+		if m := docFinished.FindStringSubmatch(line); m != nil {
+			summary.Finished = true
+			summary.Updated, _ = strconv.Atoi(m[3])
+			summary.Total, _ = strconv.Atoi(m[4])
+			summary.Elapsed = m[5]
+		} else if m := docFailed.FindStringSubmatch(line); m != nil {
+			summary.Finished = false
+			summary.Updated, _ = strconv.Atoi(m[3])
+			summary.Elapsed = m[4]
+		} else if m := docResource.FindStringSubmatch(line); m != nil {
+			summary.Resource = m[1]
+		} else if docRule.MatchString(line) && summary.Exception == "" && !strings.HasPrefix(previous, "=") && !strings.Contains(previous, " ") {
+			summary.Exception = previous
+			exception = true
+		} else if m := logComplete.FindStringSubmatch(line); m != nil {
+			summary.Finished = true
+			summary.Elapsed = m[2]
+		} else if m := logUpdated.FindStringSubmatch(line); m != nil {
+			summary.Updated, _ = strconv.Atoi(m[1])
+			summary.Total, _ = strconv.Atoi(m[2])
+		} else if m := logResource.FindStringSubmatch(line); m != nil {
+			summary.Resource = m[1]
+			summary.Exception = m[2]
+			summary.Message = m[3]
+		} else if m := logFatal.FindStringSubmatch(line); m != nil && summary.Exception == "" {
+			summary.Exception = m[1]
+			summary.Message = m[3]
+		}
+		previous = line
+	}
+	return summary
+}
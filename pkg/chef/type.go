@@ -4,6 +4,19 @@ import (
 	"github.com/foxdalas/nodeup/pkg/nodeup_const"
 	"github.com/go-chef/chef"
 	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	soloArchive  = "chef-repo.tar.gz"
+	soloRepoPath = "/var/chef/local"
+
+	// validatorName is the validation client in client.rb
+	validatorName = "chef-validator"
+
+	verifyRetry    = 10
+	verifyInterval = 6 * time.Second
 )
 
 type Chef struct {
@@ -12,7 +25,6 @@ type Chef struct {
 	ChefConfig    []byte
 	BootstrapJson []byte
 	ValidationPem []byte
-	Hosts         []byte
 
 	log *logrus.Entry
 }
@@ -22,20 +34,64 @@ type Config struct {
 	LogLocation          string
 	ChefServerUrl        string
 	ValidationClientName string
+	ValidationKey        string
 	NodeName             string
+	PolicyName           string
+	PolicyGroup          string
 }
-type Hosts struct {
-	Hostname string
-	Domain   string
+type SoloConfig struct {
+	LogLevel    string
+	LogLocation string
+	NodeName    string
+	RepoPath    string
 }
 
-type Bootstrap struct {
-	RunList []string `json:"run_list"`
+// PolicyGroup is the Chef server policy_groups/<name> response
+type PolicyGroup struct {
+	Uri      string                       `json:"uri"`
+	Policies map[string]map[string]string `json:"policies"`
+}
+
+// ClientKey is the Chef server response for a new client
+type ClientKey struct {
+	Uri        string `json:"uri"`
+	PrivateKey string `json:"private_key"`
+	ChefKey    struct {
+		PrivateKey string `json:"private_key"`
+	} `json:"chef_key"`
 }
 
 type ChefClient struct {
 	nodeup nodeup.NodeUP
 	client *chef.Client
+	name   string
 
 	log *logrus.Entry
 }
+
+type Provider struct {
+	nodeup         nodeup.NodeUP
+	client         *ChefClient
+	serverURL      string
+	validationPem  []byte
+	validationPath string
+	validatorless  bool
+	installer      *Installer
+
+	mu        sync.Mutex
+	startedAt map[string]time.Time
+}
+
+type Solo struct {
+	nodeup    nodeup.NodeUP
+	archive   []byte
+	installer *Installer
+}
+
+type Installer struct {
+	source   string
+	checksum string
+	version  string
+	pkg      string
+	data     []byte
+}
@@ -1,8 +1,32 @@
 package chef
 
-import "github.com/sirupsen/logrus"
+import (
+	"github.com/foxdalas/nodeup/pkg/nodeup_const"
+	"github.com/sirupsen/logrus"
+)
 
 func (c *ChefClient) Log() *logrus.Entry {
 	log := c.nodeup.Log().WithField("context", "ssh")
 	return log
 }
+
+// nodeAttributes returns the target normal attributes with the nodeup tags
+func nodeAttributes(target *nodeup.Target) map[string]interface{} {
+	attributes := make(map[string]interface{})
+	for key, value := range target.Attributes {
+		attributes[key] = value
+	}
+	if len(target.Tags) == 0 {
+		return attributes
+	}
+
+	var tags []interface{}
+	if existing, ok := attributes["tags"].([]interface{}); ok {
+		tags = append(tags, existing...)
+	}
+	for _, tag := range target.Tags {
+		tags = append(tags, tag)
+	}
+	attributes["tags"] = tags
+	return attributes
+}
@@ -0,0 +1,100 @@
+package chef
+
+import (
+	"fmt"
+	"github.com/foxdalas/nodeup/pkg/nodeup_const"
+	"github.com/go-chef/chef"
+	"strings"
+	"time"
+)
+
+// Verify polls the node on the Chef server until the last converge is
+// reported and matches the target
+func (p *Provider) Verify(target *nodeup.Target) error {
+	started := p.started(target.Hostname)
+
+	var err error
+	for i := 0; i < verifyRetry; i++ {
+		var node chef.Node
+		node, err = p.client.client.Nodes.Get(target.Hostname)
+		if err == nil {
+			err = checkNode(node, target, started)
+			if err == nil {
+				p.client.Log().Infof("Chef node %s converged", target.Hostname)
+				return p.restrictNode(target)
+			}
+		}
+		p.client.Log().Debugf("Chef node %s is not converged yet: %s", target.Hostname, err)
+		time.Sleep(verifyInterval)
+	}
+	return fmt.Errorf("Chef node %s: %s", target.Hostname, err)
+}
+
+// restrictNode takes back the validator's update on the client, chef-client
+// re-keyed it during the converge
+func (p *Provider) restrictNode(target *nodeup.Target) error {
+	if p.validatorless {
+		return nil
+	}
+	return p.client.GrantClient(target.Hostname, []string{p.client.name, target.Hostname}, []string{"admins"})
+}
+
+func (p *Provider) setStarted(hostname string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.startedAt == nil {
+		p.startedAt = make(map[string]time.Time)
+	}
+	p.startedAt[hostname] = time.Now()
+}
+
+func (p *Provider) started(hostname string) time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.startedAt[hostname]
+}
+
+// checkNode compares the node saved by chef-client with the target
+func checkNode(node chef.Node, target *nodeup.Target, started time.Time) error {
+	ohaiTime, _ := node.AutomaticAttributes["ohai_time"].(float64)
+	if ohaiTime < float64(started.Unix()) {
+		return fmt.Errorf("ohai_time %.0f is older than bootstrap start %d", ohaiTime, started.Unix())
+	}
+
+	if target.PolicyName != "" {
+		if node.PolicyName != target.PolicyName || node.PolicyGroup != target.PolicyGroup {
+			return fmt.Errorf("policy %s (%s) expected, got %s (%s)", target.PolicyName, target.PolicyGroup, node.PolicyName, node.PolicyGroup)
+		}
+	} else {
+		if node.Environment != target.Environment {
+			return fmt.Errorf("environment %s expected, got %s", target.Environment, node.Environment)
+		}
+		expected := normalizeRunList(target.RunList)
+		actual := normalizeRunList(node.RunList)
+		if strings.Join(expected, ",") != strings.Join(actual, ",") {
+			return fmt.Errorf("run list %s expected, got %s", strings.Join(expected, ","), strings.Join(actual, ","))
+		}
+	}
+
+	ip, _ := node.AutomaticAttributes["ipaddress"].(string)
+	// ohai reports the fixed address, GetAddress may have picked a floating one
+	for _, address := range target.ServerAddresses {
+		if address == ip {
+			return nil
+		}
+	}
+	return fmt.Errorf("ipaddress %s doesn't match openstack addresses %s", ip, strings.Join(target.ServerAddresses, ","))
+}
+
+// normalizeRunList expands bare recipe names the way the Chef server does
+func normalizeRunList(runlist []string) []string {
+	var normalized []string
+	for _, item := range runlist {
+		item = strings.TrimSpace(item)
+		if !strings.HasPrefix(item, "role[") && !strings.HasPrefix(item, "recipe[") {
+			item = "recipe[" + item + "]"
+		}
+		normalized = append(normalized, item)
+	}
+	return normalized
+}
@@ -3,17 +3,21 @@ package cmd
 import (
 	"errors"
 	"flag"
+	"github.com/foxdalas/nodeup/pkg/ansible"
 	"github.com/foxdalas/nodeup/pkg/chef"
 	"github.com/foxdalas/nodeup/pkg/migrate"
 	"github.com/foxdalas/nodeup/pkg/nodeup"
 	"github.com/foxdalas/nodeup/pkg/openstack"
 	"github.com/foxdalas/nodeup/pkg/rebalance"
 	"github.com/foxdalas/nodeup/pkg/rest"
+	"github.com/foxdalas/nodeup/pkg/script"
+	"github.com/foxdalas/nodeup/pkg/ssh"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
 	"os"
 	"os/user"
 	"strings"
+	"time"
 )
 
 func Run(version string) {
@@ -83,23 +87,75 @@ func makeLog() *log.Entry {
 func createConnect(o *nodeup.NodeUP) {
 	var err error
 
-	enableChef := true
+	enableBootstrap := true
 	if o.Migrate {
-		enableChef = false
+		enableBootstrap = false
 	}
 	if o.Rebalance {
-		enableChef = false
+		enableBootstrap = false
 	}
 
 	o.Openstack = openstack.New(o, o.OSPublicKey, o.OSKeyName, o.OSFlavorName)
-	if enableChef {
+	if enableBootstrap && o.ChefEnabled() {
 		o.Chef, err = chef.NewChefClient(o, o.ChefClientName, o.ChefKeyPem, o.ChefServerUrl)
 		if err != nil {
 			o.Log().Fatal(err)
 		}
 	}
+
+	if enableBootstrap && !o.Daemon {
+		switch o.BootstrapProvider {
+		case "chef":
+			installer, err := chef.NewInstaller(o.ChefPackageSource, o.ChefPackageChecksum, o.ChefVersion)
+			if err != nil {
+				o.Log().Fatal(err)
+			}
+			o.Bootstrap = chef.NewProvider(o, o.Chef, o.ChefServerUrl, o.ChefValidationPem, o.ChefValidationPath, o.ChefValidatorless, installer)
+		case "chef-solo":
+			installer, err := chef.NewInstaller(o.ChefPackageSource, o.ChefPackageChecksum, o.ChefVersion)
+			if err != nil {
+				o.Log().Fatal(err)
+			}
+			o.Bootstrap, err = chef.NewSolo(o, o.ChefArchive, installer)
+			if err != nil {
+				o.Log().Fatal(err)
+			}
+		case "script":
+			o.Bootstrap, err = script.New(o, o.ScriptDir, o.ScriptEntrypoint)
+			if err != nil {
+				o.Log().Fatal(err)
+			}
+		case "ansible":
+			for environment := range o.JumpHosts {
+				_, err = o.ProxyJump(environment)
+				if err != nil {
+					o.Log().Fatal(err)
+				}
+			}
+			sshOptions := ansible.SSH{
+				Key:       o.SSHKey,
+				Cert:      o.SSHCert,
+				ProxyJump: o.ProxyJump,
+			}
+			if o.HostKeyCheck != "off" {
+				sshOptions.KnownHosts = o.KnownHostsPath
+			}
+			o.Bootstrap, err = ansible.New(o, o.AnsiblePlaybook, o.SSHUser, o.AnsibleArgs, sshOptions)
+			if err != nil {
+				o.Log().Fatal(err)
+			}
+		}
+
+		err = o.ValidateCloudInit()
+		if err != nil {
+			o.Log().Fatal(err)
+		}
+	}
 }
 
+// sshWaitInterval is the retry interval of the deprecated -sshWaitRetry
+const sshWaitInterval = 10 * time.Second
+
 func params(o *nodeup.NodeUP) error {
 
 	usr, err := user.Current()
@@ -110,30 +166,69 @@ func params(o *nodeup.NodeUP) error {
 	flag.StringVar(&o.Name, "name", "", "Hostname or  mask like role-environment-* or full-hostname-name if -count 1")
 	flag.StringVar(&o.Domain, "domain", "", "Domain name like hosts.example.com")
 	flag.StringVar(&o.AvailabilityZone, "availability-zone", "", "Select availability-zone.")
+	flag.StringVar(&o.SpecPath, "spec", "", "Fleet spec file (YAML) with host groups. Flags are used as group defaults")
 	flag.StringVar(&o.LogDir, "logDir", "logs", "Logs directory")
 	flag.IntVar(&o.Count, "count", 1, "Deployment hosts count")
 	flag.StringVar(&o.OSFlavorName, "flavor", "", "Openstack flavor name")
+	flag.StringVar(&o.OSImage, "image", "", "Openstack image name or ID. Ubuntu 16.04-server (64 bit) if -imageProperties is not set")
+	flag.StringVar(&o.OSImageProperties, "imageProperties", "", "Select newest image by metadata like os_distro=ubuntu,os_version=22.04")
 	flag.StringVar(&o.OSGroupID, "group", "", "Openstack groupID")
 	flag.StringVar(&o.ChefEnvironment, "chefEnvironment", "", "Environment name for host")
 	flag.StringVar(&o.ChefRole, "chefRole", "", "Role name for host")
+	flag.StringVar(&o.ChefRunList, "runList", "", "Chef run list like role[base],recipe[nginx::default]. Overrides -chefRole")
+	flag.StringVar(&o.ChefAttributesPath, "attributes", "", "Node attributes file (JSON or YAML) for bootstrap.json")
+	flag.StringVar(&o.ChefPolicyName, "chefPolicyName", "", "Policyfile name for host. Replaces -chefRole and -chefEnvironment")
+	flag.StringVar(&o.ChefPolicyGroup, "chefPolicyGroup", "", "Policy group for host")
+	flag.Var((*stringsFlag)(&o.FileSpecs), "file", "Extra file SOURCE:DEST[:OWNER[:MODE]] installed before bootstrap, SOURCE is a path or $VARIABLE, OWNER is user or user.group. Can be repeated")
+	flag.Var((*stringsFlag)(&o.PreHookSpecs), "preBootstrapHook", "Executable run before the bootstrap provider, remote:path runs the script on the host. Can be repeated")
+	flag.Var((*stringsFlag)(&o.PostHookSpecs), "postBootstrapHook", "Executable run after a successful bootstrap, remote:path runs the script on the host. Can be repeated")
+	flag.BoolVar(&o.CloudInit, "cloudInit", false, "Bootstrap with cloud-init user-data instead of SSH")
+	flag.DurationVar(&o.CloudInitTimeout, "cloudInitTimeout", 30*time.Minute, "Time to wait for the cloud-init bootstrap")
+	flag.StringVar(&o.ChecksPath, "checks", "", "Smoke tests (YAML) by role run over SSH after bootstrap")
+	flag.Var((*stringsFlag)(&o.ChefAttrs), "attr", "Node attribute like mongodb.shard=3, can be repeated. Overrides -attributes")
 	flag.StringVar(&o.OSKeyName, "keyName", usr.Username, "Openstack admin key name")
 	flag.StringVar(&o.OSPublicKeyPath, "publicKeyPath", "", "Openstack admin key path")
 	flag.StringVar(&o.User, "user", "cloud-user", "Openstack user")
 	flag.BoolVar(&o.IgnoreFail, "ignoreFail", false, "Don't delete host after fail")
-	flag.IntVar(&o.Concurrency, "concurrency", 5, "Concurrency bootstrap")
+	flag.IntVar(&o.Concurrency, "concurrency", 5, "Parallel workers for each phase (create, ssh, bootstrap)")
 	flag.IntVar(&o.PrefixCharts, "prefixCharts", 5, "Host mask random prefix")
-	flag.IntVar(&o.SSHWaitRetry, "sshWaitRetry", 20, "SSH Retry count")
+	flag.DurationVar(&o.ReadyTCPTimeout, "readyTCPTimeout", 5*time.Minute, "Time to wait for SSH port, 0 to skip")
+	flag.DurationVar(&o.ReadySSHTimeout, "readySSHTimeout", 2*time.Minute, "Time to wait for SSH login, 0 to skip with the later stages")
+	sshWaitRetry := flag.Int("sshWaitRetry", 0, "Deprecated, use -readySSHTimeout. SSH retry count, 10 seconds each")
+	flag.DurationVar(&o.ReadyCloudInitTimeout, "readyCloudInitTimeout", 10*time.Minute, "Time to wait for cloud-init to finish, 0 to skip")
+	flag.StringVar(&o.ReadyCommand, "readyCommand", "", "Command that must succeed over SSH before bootstrap")
+	flag.DurationVar(&o.ReadyCommandTimeout, "readyCommandTimeout", 5*time.Minute, "Time to wait for -readyCommand")
+	flag.StringVar(&o.BootstrapProvider, "bootstrap", "chef", "Bootstrap provider: chef, chef-solo, script or ansible")
+	flag.StringVar(&o.ScriptDir, "scriptDir", "", "Local directory uploaded to the host by the script provider")
+	flag.StringVar(&o.ScriptEntrypoint, "scriptEntrypoint", "bootstrap.sh", "Executable in -scriptDir run with sudo by the script provider")
+	flag.StringVar(&o.AnsiblePlaybook, "ansiblePlaybook", "", "Playbook run by the ansible provider")
+	flag.StringVar(&o.AnsibleArgs, "ansibleArgs", "", "Extra ansible-playbook arguments like \"-e foo=bar --tags base\"")
 	flag.StringVar(&o.ChefVersion, "chefVersion", "12.20.3", "chef-client version")
+	flag.StringVar(&o.ChefPackageSource, "chefPackageSource", "", "chef-client package source: \"repo\" for the image apt/yum repos, a .deb/.rpm mirror URL or local path. Omnitruck if empty")
+	flag.StringVar(&o.ChefPackageChecksum, "chefPackageChecksum", "", "SHA256 of the -chefPackageSource package")
 	flag.StringVar(&o.ChefServerUrl, "chefServerUrl", "", "Chef Server URL")
+	flag.StringVar(&o.ChefArchive, "chefArchive", "", "Cookbooks archive (tar.gz of a chef repo) for -bootstrap chef-solo")
 	flag.StringVar(&o.ChefClientName, "chefClientName", "", "Chef client name")
 	flag.StringVar(&o.ChefKeyPath, "chefKeyPath", "", "Chef client certificate path")
 	flag.StringVar(&o.ChefValidationPath, "chefValidationPath", "", "Validation key path or CHEF_VALIDATION_PEM")
+	flag.BoolVar(&o.ChefValidatorless, "chefValidatorless", false, "Create chef client and node with -chefClientName instead of uploading the validation key")
 	flag.StringVar(&o.SSHUser, "sshUser", "cloud-user", "SSH Username")
-	flag.StringVar(&o.SSHUploadDir, "sshUploadDir", "/home/"+o.SSHUser, "SSH Upload directory")
+	flag.StringVar(&o.SSHKey, "sshKey", "", "SSH private key, passphrase from NODEUP_SSH_KEY_PASSPHRASE")
+	flag.StringVar(&o.SSHCert, "sshCert", "", "OpenSSH user certificate for -sshKey (default KEY-cert.pub if present)")
+	flag.StringVar(&o.SSHAuthOrder, "sshAuth", "agent,cert,key,password", "SSH authentication methods in order, password from NODEUP_SSH_PASSWORD")
+	flag.DurationVar(&o.CommandTimeout, "commandTimeout", 30*time.Minute, "Timeout of every command run over SSH, 0 for none")
+	flag.DurationVar(&o.BootstrapTimeout, "bootstrapTimeout", 2*time.Hour, "Timeout of all bootstrap commands of a host, 0 for none")
+	flag.StringVar(&o.SSHUploadDir, "sshUploadDir", "", "SSH Upload directory (default the -sshUser home)")
 	flag.StringVar(&o.DefineNetworks, "networks", "", "Define networks like internet_XX.XX.XX.XX/XX,local_private,global_private")
+	flag.StringVar(&o.HostKeyCheck, "hostKeyCheck", "console", "SSH host key check for new servers: console (fingerprints from the console log), tofu, strict or off. Existing servers are always checked strictly unless off")
+	flag.StringVar(&o.KnownHostsPath, "knownHosts", usr.HomeDir+"/.nodeup/known_hosts", "known_hosts file managed by nodeup")
+	flag.StringVar(&o.JumpHost, "jumpHost", "", "SSH jump hosts like user@bastion:22,user@bastion2 for all environments")
+	flag.StringVar(&o.JumpHostsPath, "jumpHosts", "", "SSH jump hosts (YAML) by chef environment")
 	flag.StringVar(&o.WebSSHUser, "web.sshUser", "cloud-user", "SSH User for Web Management")
 
 	flag.BoolVar(&o.JenkinsMode, "jenkinsMode", false, "Jenkins capability mode")
+	flag.BoolVar(&o.PlanMode, "plan", false, "Resolve hosts, flavors, images and networks without creating anything")
+	flag.StringVar(&o.PlanFormat, "planFormat", "text", "Plan output format: text or json")
 
 	flag.StringVar(&o.DeleteNodes, "deleteNodes", "", "Delete mode. Please use -deleteNodes node_name1, node_name2")
 	flag.BoolVar(&o.Daemon, "daemon", false, "Use HTTP daemon")
@@ -147,16 +242,77 @@ func params(o *nodeup.NodeUP) error {
 
 	o.Gateway = os.Getenv("GATEWAY")
 
-	enableChef := true
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	if set["sshWaitRetry"] {
+		o.Log().Warn("-sshWaitRetry is deprecated, use -readySSHTimeout")
+		if !set["readySSHTimeout"] {
+			o.ReadySSHTimeout = time.Duration(*sshWaitRetry+1) * sshWaitInterval
+		}
+	}
+	if o.SSHUploadDir == "" {
+		o.SSHUploadDir = "/home/" + o.SSHUser
+	}
+
+	if o.PlanFormat != "text" && o.PlanFormat != "json" {
+		return errors.New("Please provide -planFormat text or json")
+	}
+
+	switch o.HostKeyCheck {
+	case "console", "tofu", "strict", "off":
+	default:
+		return errors.New("Please provide -hostKeyCheck console, tofu, strict or off")
+	}
+	// the console fingerprints are read by the SSH readiness stage
+	if o.HostKeyCheck == "console" && o.ReadySSHTimeout <= 0 && !o.CloudInit {
+		return errors.New("-hostKeyCheck console needs the SSH readiness stage, set -readySSHTimeout or use -hostKeyCheck tofu")
+	}
+	o.KnownHosts, err = ssh.NewKnownHosts(o.KnownHostsPath)
+	if err != nil {
+		return err
+	}
+	o.UserKnownHosts = usr.HomeDir + "/.ssh/known_hosts"
+	o.SSHAuth, err = ssh.NewAuth(strings.Split(o.SSHAuthOrder, ","), o.SSHKey, o.SSHCert, os.Getenv(ssh.KeyPassphraseEnv), os.Getenv(ssh.PasswordEnv))
+	if err != nil {
+		return err
+	}
+	err = o.LoadJumpHosts()
+	if err != nil {
+		return err
+	}
+
+	enableBootstrap := true
 	if o.Migrate {
-		enableChef = false
+		enableBootstrap = false
 	}
 	if o.Rebalance {
-		enableChef = false
+		enableBootstrap = false
 	}
 
-	if enableChef {
-		if o.ChefValidationPath == "" && len(os.Getenv("CHEF_VALIDATION_PEM")) == 0 {
+	switch o.BootstrapProvider {
+	case "chef":
+	case "chef-solo":
+		if o.ChefArchive == "" && enableBootstrap && !o.Daemon {
+			return errors.New("Please provide -chefArchive string")
+		}
+	case "script":
+		if o.ScriptDir == "" && enableBootstrap && !o.Daemon {
+			return errors.New("Please provide -scriptDir string")
+		}
+	case "ansible":
+		if o.AnsiblePlaybook == "" && enableBootstrap && !o.Daemon {
+			return errors.New("Please provide -ansiblePlaybook string")
+		}
+	default:
+		return errors.New("Please provide -bootstrap chef, chef-solo, script or ansible")
+	}
+
+	if enableBootstrap && o.ChefEnabled() {
+		if o.ChefValidatorless {
+			o.Log().Info("Validatorless bootstrap, validation key is not used")
+		} else if o.ChefValidationPath == "" && len(os.Getenv("CHEF_VALIDATION_PEM")) == 0 {
 			return errors.New("Please provide -chefValidationPath or environment variable CHEF_VALIDATION_PEM")
 		} else {
 			if len(os.Getenv("CHEF_VALIDATION_PEM")) > 0 {
@@ -196,14 +352,17 @@ func params(o *nodeup.NodeUP) error {
 			}
 		}
 
-		if (o.ChefRole == "" && o.DeleteNodes == "") && !o.Daemon {
-			return errors.New("Please provide -chefRole string")
+		if (o.ChefRole == "" && o.ChefRunList == "" && o.ChefPolicyName == "" && o.DeleteNodes == "" && o.SpecPath == "") && !o.Daemon {
+			return errors.New("Please provide -chefRole, -runList or -chefPolicyName string")
 		}
 
-		if (o.ChefEnvironment == "" && o.DeleteNodes == "") && !o.Daemon {
+		if (o.ChefEnvironment == "" && o.ChefPolicyName == "" && o.DeleteNodes == "" && o.SpecPath == "") && !o.Daemon {
 			return errors.New("Please provide -chefEnvironment string")
 		}
-		if (o.Name == "" && o.DeleteNodes == "") && !o.Daemon {
+	}
+
+	if enableBootstrap {
+		if (o.Name == "" && o.DeleteNodes == "" && o.SpecPath == "") && !o.Daemon {
 			return errors.New("Please provide -name string")
 		}
 
@@ -211,7 +370,7 @@ func params(o *nodeup.NodeUP) error {
 			return errors.New("Please provide -domain string")
 		}
 
-		if (o.Count == 0 && o.DeleteNodes == "") && !o.Daemon {
+		if (o.Count == 0 && o.DeleteNodes == "" && o.SpecPath == "") && !o.Daemon {
 			return errors.New("Please provide -count int")
 		}
 
@@ -229,13 +388,32 @@ func params(o *nodeup.NodeUP) error {
 			}
 		}
 
-		if (o.OSFlavorName == "" && o.DeleteNodes == "") && !o.Daemon {
+		if (o.OSFlavorName == "" && o.DeleteNodes == "" && o.SpecPath == "") && !o.Daemon {
 			return errors.New("Please provide -flavor string")
 		}
 
 		if (o.OSKeyName == "" && o.DeleteNodes == "") && !o.Daemon {
 			return errors.New("Please provide -keyname string")
 		}
+
+		if o.DeleteNodes == "" && !o.Daemon {
+			err = o.LoadGroups()
+			if err != nil {
+				return err
+			}
+			err = o.LoadFiles()
+			if err != nil {
+				return err
+			}
+			err = o.LoadHooks()
+			if err != nil {
+				return err
+			}
+			err = o.LoadChecks()
+			if err != nil {
+				return err
+			}
+		}
 	} else {
 		if !o.Rebalance {
 			if o.Hosts == "" {
@@ -288,5 +466,20 @@ func params(o *nodeup.NodeUP) error {
 		o.JenkinsLogURL = os.Getenv("JOB_URL") + "ws/logs/"
 	}
 
+	o.Creator = usr.Username
+	o.BuildURL = os.Getenv("BUILD_URL")
+
+	return nil
+}
+
+// stringsFlag collects values of a repeated flag
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
 	return nil
 }
@@ -0,0 +1,106 @@
+package nodeup
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"strings"
+)
+
+// loadAttributes reads node attributes from a JSON or YAML file
+func loadAttributes(path string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var attributes map[string]interface{}
+	if strings.HasSuffix(path, ".json") {
+		err = json.Unmarshal(data, &attributes)
+	} else {
+		var raw map[interface{}]interface{}
+		err = yaml.Unmarshal(data, &raw)
+		attributes = stringMap(raw)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Attributes %s: %s", path, err)
+	}
+	return attributes, nil
+}
+
+// setAttribute sets key.path=value. The value is parsed as JSON if
+// possible, so numbers, booleans and lists keep their type.
+func setAttribute(attributes map[string]interface{}, attr string) error {
+	kv := strings.SplitN(attr, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("Invalid attribute %s, expected key.path=value", attr)
+	}
+
+	var value interface{}
+	err := json.Unmarshal([]byte(kv[1]), &value)
+	if err != nil {
+		value = kv[1]
+	}
+
+	keys := strings.Split(kv[0], ".")
+	node := attributes
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := node[key].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			node[key] = next
+		}
+		node = next
+	}
+	node[keys[len(keys)-1]] = value
+	return nil
+}
+
+// mergeAttributes deep merges src into dst. Values from src win.
+func mergeAttributes(dst map[string]interface{}, src map[string]interface{}) map[string]interface{} {
+	if dst == nil {
+		dst = make(map[string]interface{})
+	}
+	for key, value := range src {
+		srcMap, srcOk := value.(map[string]interface{})
+		dstMap, dstOk := dst[key].(map[string]interface{})
+		if srcOk && dstOk {
+			dst[key] = mergeAttributes(dstMap, srcMap)
+		} else if srcOk {
+			dst[key] = mergeAttributes(nil, srcMap)
+		} else {
+			dst[key] = value
+		}
+	}
+	return dst
+}
+
+// stringMap converts maps decoded by yaml.v2 into JSON compatible maps
+func stringMap(m map[interface{}]interface{}) map[string]interface{} {
+	result := make(map[string]interface{})
+	for key, value := range m {
+		result[fmt.Sprint(key)] = stringValue(value)
+	}
+	return result
+}
+
+func stringValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		return stringMap(v)
+	case map[string]interface{}:
+		result := make(map[string]interface{})
+		for key, item := range v {
+			result[key] = stringValue(item)
+		}
+		return result
+	case []interface{}:
+		for i, item := range v {
+			v[i] = stringValue(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
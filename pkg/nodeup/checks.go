@@ -0,0 +1,130 @@
+package nodeup
+
+import (
+	"errors"
+	"fmt"
+	"github.com/foxdalas/nodeup/pkg/ssh"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	checkRetry    = 5
+	checkInterval = 5 * time.Second
+)
+
+// LoadChecks reads smoke tests per role from the -checks file
+func (o *NodeUP) LoadChecks() error {
+	if o.ChecksPath == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(o.ChecksPath)
+	if err != nil {
+		return err
+	}
+
+	checks := &Checks{}
+	err = yaml.UnmarshalStrict(data, checks)
+	if err != nil {
+		return fmt.Errorf("Checks %s: %s", o.ChecksPath, err)
+	}
+	for role, list := range checks.Roles {
+		for _, check := range list {
+			_, err = check.command()
+			if err != nil {
+				return fmt.Errorf("Checks %s: role %s: %s", o.ChecksPath, role, err)
+			}
+		}
+	}
+	o.Checks = checks
+	return nil
+}
+
+// hostChecks returns the checks for every role in the host run list and the
+// checks of its group
+func (o *NodeUP) hostChecks(host *Host) []*Check {
+	var checks []*Check
+	if o.Checks != nil {
+		checks = append(checks, o.Checks.Roles["*"]...)
+		for _, item := range host.Group.RunList {
+			if strings.HasPrefix(item, "role[") && strings.HasSuffix(item, "]") {
+				checks = append(checks, o.Checks.Roles[item[5:len(item)-1]]...)
+			}
+		}
+	}
+	return append(checks, host.Group.Checks...)
+}
+
+// runChecks runs the host checks over SSH. Every check is retried a few times
+// to give services time to start after the converge.
+func (o *NodeUP) runChecks(host *Host, executor *ssh.Executor) error {
+	var failed []string
+	for _, check := range o.hostChecks(host) {
+		command, err := check.command()
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < checkRetry; i++ {
+			_, err = executor.Run(command, host.LogFile)
+			if err == nil {
+				break
+			}
+			time.Sleep(checkInterval)
+		}
+		if err != nil {
+			o.Log().Errorf("Host %s check %s failed", host.Hostname, check)
+			failed = append(failed, check.String())
+			continue
+		}
+		o.Log().Debugf("Host %s check %s passed", host.Hostname, check)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("Checks failed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// command returns the shell command for the check, exiting 0 on success
+func (c *Check) command() (string, error) {
+	set := 0
+	var command string
+	if c.Port != 0 {
+		set++
+		command = "(ss -ltn 2>/dev/null || netstat -ltn) | awk '{print $4}' | grep -Eq '[:.]" + strconv.Itoa(c.Port) + "$'"
+	}
+	if c.Unit != "" {
+		set++
+		command = "systemctl is-active --quiet " + shellQuote(c.Unit)
+	}
+	if c.HTTP != "" {
+		set++
+		command = "test \"$(curl -sS -o /dev/null -w '%{http_code}' " + shellQuote(c.HTTP) + ")\" = 200"
+	}
+	if c.File != "" {
+		set++
+		command = "sudo test -e " + shellQuote(c.File)
+	}
+	if set != 1 {
+		return "", errors.New("check must have exactly one of port, unit, http or file")
+	}
+	return command, nil
+}
+
+func (c *Check) String() string {
+	switch {
+	case c.Port != 0:
+		return "port " + strconv.Itoa(c.Port)
+	case c.Unit != "":
+		return "unit " + c.Unit
+	case c.HTTP != "":
+		return "http " + c.HTTP
+	default:
+		return "file " + c.File
+	}
+}
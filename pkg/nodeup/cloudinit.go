@@ -0,0 +1,193 @@
+package nodeup
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/foxdalas/nodeup/pkg/nodeup_const"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	cloudInitFinished = "nodeup: bootstrap finished"
+	cloudInitFailed   = "nodeup: bootstrap failed"
+	cloudInitInterval = 15 * time.Second
+
+	// nova limit for base64 encoded user-data
+	userDataLimit = 65535
+
+	cloudInitCopies = "/var/lib/cloud/instance/user-data.txt /var/lib/cloud/instance/user-data.txt.i /var/lib/cloud/instance/scripts/part-*"
+)
+
+// userData renders the whole bootstrap into a cloud-init user-data script:
+// the upload files are written from base64 and the commands are run in order.
+// user-data can be read from the metadata service by any process on the
+// host, see ValidateCloudInit.
+// The result is reported to the console for waitCloudInit.
+func (o *NodeUP) userData(host *Host) ([]byte, error) {
+	host.Family = familyByDistro(host.Group.resolved.Distro)
+	target := o.target(host)
+
+	files, err := o.Bootstrap.Prepare(target)
+	if err != nil {
+		return nil, err
+	}
+	files["hosts"] = o.createHostsFile(host.Hostname, o.Domain)
+	for name, data := range o.hookFiles() {
+		files[name] = data
+	}
+
+	commands := append(o.runCommands(host.Hostname, host.Family), o.hookCommands(o.PreHooks, target)...)
+	commands = append(commands, o.Bootstrap.Commands(target)...)
+	commands = append(commands, o.hookCommands(remoteHooks(o.PostHooks), target)...)
+
+	data := renderUserData(o.SSHUploadDir, files, commands)
+	if base64.StdEncoding.EncodedLen(len(data)) > userDataLimit {
+		return nil, fmt.Errorf("User data for host %s is %d bytes, more than the %d bytes OpenStack allows", host.Hostname, len(data), userDataLimit)
+	}
+	return data, nil
+}
+
+// ValidateCloudInit rejects everything needing SSH access in -cloudInit mode
+func (o *NodeUP) ValidateCloudInit() error {
+	if !o.CloudInit {
+		return nil
+	}
+	if _, ok := o.Bootstrap.(nodeup.Runner); ok {
+		return fmt.Errorf("Bootstrap provider %s needs SSH access and can't be used with -cloudInit", o.BootstrapProvider)
+	}
+	if len(localHooks(o.PreHooks)) > 0 {
+		return errors.New("Local -preBootstrapHook can't be used with -cloudInit, use remote:")
+	}
+	// user-data stays readable from the metadata service for every local
+	// process, keys with access to more than the host itself are not put there
+	if o.BootstrapProvider == "chef" && !o.ChefValidatorless {
+		return errors.New("The validation key can't be used with -cloudInit, use -chefValidatorless")
+	}
+	if len(o.Files) > 0 {
+		return errors.New("-file can't be used with -cloudInit, user-data is readable on the host")
+	}
+	if o.Checks != nil {
+		return errors.New("-checks need SSH access and can't be used with -cloudInit")
+	}
+	for _, group := range o.Groups {
+		if len(group.Checks) > 0 {
+			return fmt.Errorf("Group %s: checks need SSH access and can't be used with -cloudInit", group.Name)
+		}
+	}
+	return nil
+}
+
+func renderUserData(dir string, files map[string][]byte, commands []string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("#!/bin/bash\n")
+	buf.WriteString("notify() { echo \"$1\"; echo \"$1\" > /dev/console 2>/dev/null; }\n")
+	buf.WriteString("run() { bash -c \"$1\" || { notify '" + cloudInitFailed + "'; exit 1; }; }\n")
+	// commands are written for a non-root SSH user, cloud-init runs as root
+	buf.WriteString("sudo() { \"$@\"; }\n")
+	buf.WriteString("export -f sudo\n")
+	buf.WriteString("mkdir -p " + dir + "\n")
+	// commands use paths relative to the upload directory like over SSH
+	buf.WriteString("cd " + dir + "\n")
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// the uploaded files and cloud-init's copies of user-data are removed
+	// whatever the result
+	buf.WriteString("cleanup() { rm -f")
+	for _, name := range names {
+		buf.WriteString(" " + dir + "/" + name)
+	}
+	buf.WriteString(" " + cloudInitCopies + "; }\n")
+	buf.WriteString("trap cleanup EXIT\n")
+
+	for _, name := range names {
+		umask := fmt.Sprintf("%03o", 0777&^uploadMode(name))
+		buf.WriteString("(umask " + umask + "; base64 -d > " + dir + "/" + name + ") <<'NODEUP_EOF'\n")
+		buf.WriteString(base64.StdEncoding.EncodeToString(files[name]) + "\n")
+		buf.WriteString("NODEUP_EOF\n")
+	}
+
+	for _, command := range commands {
+		buf.WriteString("run " + shellQuote(command) + "\n")
+	}
+	buf.WriteString("notify '" + cloudInitFinished + "'\n")
+	return buf.Bytes()
+}
+
+// waitCloudInit polls the console log until the user-data script reports the
+// result, then verifies the bootstrap like bootstrapHost does.
+func (o *NodeUP) waitCloudInit(host *Host) bool {
+	defer host.LogFile.Close()
+
+	host.Addresses = o.GetAddress(host.Server.Addresses)
+	target := o.target(host)
+
+	o.Log().Infof("Waiting cloud-init bootstrap of host %s", host.Hostname)
+	console, err := o.waitConsole(host.Server.ID)
+	// the console holds the bootstrap output, keep it as the host log
+	host.LogFile.WriteString(console)
+	if o.assertBootstrap(host, err) {
+		return false
+	}
+
+	err = o.Bootstrap.Verify(target)
+	if o.assertBootstrap(host, err) {
+		return false
+	}
+
+	err = o.runHooks(localHooks(o.PostHooks), host, nil, target)
+	if o.assertBootstrap(host, err) {
+		return false
+	}
+	return true
+}
+
+func (o *NodeUP) waitConsole(id string) (string, error) {
+	deadline := time.Now().Add(o.CloudInitTimeout)
+	var console string
+	for time.Now().Before(deadline) {
+		time.Sleep(cloudInitInterval)
+
+		var err error
+		console, err = o.Openstack.ConsoleOutput(id, 0)
+		if err != nil {
+			o.Log().Debugf("Console log of server %s: %s", id, err)
+			continue
+		}
+		if strings.Contains(console, cloudInitFailed) {
+			return console, errors.New("cloud-init bootstrap failed, see the console log")
+		}
+		if strings.Contains(console, cloudInitFinished) {
+			return console, nil
+		}
+	}
+	return console, fmt.Errorf("cloud-init bootstrap is not finished after %s", o.CloudInitTimeout)
+}
+
+func remoteHooks(hooks []*Hook) []*Hook {
+	var remote []*Hook
+	for _, hook := range hooks {
+		if hook.Remote {
+			remote = append(remote, hook)
+		}
+	}
+	return remote
+}
+
+func localHooks(hooks []*Hook) []*Hook {
+	var local []*Hook
+	for _, hook := range hooks {
+		if !hook.Remote {
+			local = append(local, hook)
+		}
+	}
+	return local
+}
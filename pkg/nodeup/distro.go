@@ -0,0 +1,124 @@
+package nodeup
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/foxdalas/nodeup/pkg/ssh"
+	"strings"
+	"text/template"
+)
+
+// families holds bootstrap command templates per distro family.
+// Templates are rendered with CommandData.
+var families = map[string]*Family{
+	"debian": {
+		Name:     "debian",
+		Hostname: "sudo mv {{ .UploadDir }}/hosts /etc/hosts && sudo hostname -F /etc/hostname",
+		Update:   "sudo apt-get update",
+		Gateway: []string{
+			"sudo mv {{ .UploadDir }}/interfaces /etc/network/",
+			"sudo route add default gw {{ .Gateway }}",
+		},
+		Interfaces: true,
+	},
+	"rhel": {
+		Name:     "rhel",
+		Hostname: "sudo mv {{ .UploadDir }}/hosts /etc/hosts && sudo restorecon /etc/hosts && sudo hostnamectl set-hostname {{ .Hostname }}",
+		Update:   "sudo yum makecache",
+		Gateway: []string{
+			"sudo sed -i '/^GATEWAY=/d' /etc/sysconfig/network && echo 'GATEWAY={{ .Gateway }}' | sudo tee -a /etc/sysconfig/network",
+			"sudo ip route replace default via {{ .Gateway }}",
+		},
+	},
+	"suse": {
+		Name:     "suse",
+		Hostname: "sudo mv {{ .UploadDir }}/hosts /etc/hosts && sudo hostnamectl set-hostname {{ .Hostname }}",
+		Update:   "sudo zypper --non-interactive refresh",
+		Gateway: []string{
+			"echo 'default {{ .Gateway }} - -' | sudo tee /etc/sysconfig/network/routes",
+			"sudo ip route replace default via {{ .Gateway }}",
+		},
+	},
+}
+
+// distroFamilies maps os-release IDs and image os_distro values to a family
+var distroFamilies = map[string]string{
+	"debian":    "debian",
+	"ubuntu":    "debian",
+	"rhel":      "rhel",
+	"centos":    "rhel",
+	"rocky":     "rhel",
+	"almalinux": "rhel",
+	"fedora":    "rhel",
+	"ol":        "rhel",
+	"suse":      "suse",
+	"sles":      "suse",
+	"opensuse":  "suse",
+}
+
+// familyByDistro returns the family for a distro ID. Unknown distros are
+// treated as Debian like the images nodeup was written for.
+func familyByDistro(distro string) *Family {
+	distro = strings.ToLower(distro)
+	if strings.HasPrefix(distro, "opensuse") {
+		distro = "opensuse"
+	}
+	if name, ok := distroFamilies[distro]; ok {
+		return families[name]
+	}
+	return families["debian"]
+}
+
+// detectFamily reads /etc/os-release on the host. ID is checked first,
+// then every ID_LIKE entry. If nothing matches, fallback is used.
+func (o *NodeUP) detectFamily(executor *ssh.Executor, fallback string) *Family {
+	data, err := executor.Output("cat /etc/os-release")
+	if err != nil {
+		o.Log().Warnf("Can't read /etc/os-release, using image distro %s: %s", fallback, err)
+		return familyByDistro(fallback)
+	}
+
+	release := parseOSRelease(data)
+	ids := append([]string{release["ID"]}, strings.Fields(release["ID_LIKE"])...)
+	for _, id := range ids {
+		if strings.HasPrefix(id, "opensuse") {
+			id = "opensuse"
+		}
+		if name, ok := distroFamilies[id]; ok {
+			o.Log().Debugf("Detected %s %s, using %s commands", release["ID"], release["VERSION_ID"], name)
+			return families[name]
+		}
+	}
+	o.Log().Warnf("Unknown distro %s, using image distro %s", release["ID"], fallback)
+	return familyByDistro(fallback)
+}
+
+func parseOSRelease(data []byte) map[string]string {
+	release := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		kv := strings.SplitN(strings.TrimSpace(scanner.Text()), "=", 2)
+		if len(kv) != 2 || strings.HasPrefix(kv[0], "#") {
+			continue
+		}
+		release[kv[0]] = strings.ToLower(strings.Trim(kv[1], `"'`))
+	}
+	return release
+}
+
+func (o *NodeUP) renderCommands(commands []string, data *CommandData) []string {
+	var result []string
+	for _, command := range commands {
+		var buf bytes.Buffer
+		t, err := template.New("command").Parse(command)
+		if err != nil {
+			o.Log().Fatal(err)
+		}
+		err = t.Execute(&buf, data)
+		if err != nil {
+			o.Log().Fatal(err)
+		}
+		result = append(result, buf.String())
+	}
+	return result
+}
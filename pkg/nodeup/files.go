@@ -0,0 +1,123 @@
+package nodeup
+
+import (
+	"fmt"
+	"github.com/foxdalas/nodeup/pkg/ssh"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LoadFiles reads the extra files passed with -file. A file is given as
+// SOURCE:DEST[:OWNER[:MODE]], SOURCE is a local path or $VARIABLE, OWNER is
+// user or user.group. A directory SOURCE is copied into DEST with all files
+// in it.
+func (o *NodeUP) LoadFiles() error {
+	o.Files = nil
+	for _, spec := range o.FileSpecs {
+		file, err := parseFile(spec)
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(file.Source, "$") {
+			value, ok := os.LookupEnv(file.Source[1:])
+			if !ok {
+				return fmt.Errorf("File %s: environment variable %s is not set", file.Dest, file.Source)
+			}
+			file.Data = []byte(value)
+		} else if info, err := os.Stat(file.Source); err == nil && info.IsDir() {
+			tree, err := ssh.Tree(file.Source, file.Dest)
+			if err != nil {
+				return fmt.Errorf("File %s: %s", file.Dest, err)
+			}
+			for _, upload := range tree {
+				o.Files = append(o.Files, &File{
+					Source: file.Source,
+					Dest:   upload.Path,
+					Owner:  file.Owner,
+					Mode:   file.Mode,
+					Data:   upload.Data,
+				})
+			}
+			continue
+		} else {
+			file.Data, err = ioutil.ReadFile(file.Source)
+			if err != nil {
+				return fmt.Errorf("File %s: %s", file.Dest, err)
+			}
+		}
+		o.Files = append(o.Files, file)
+	}
+	return nil
+}
+
+func parseFile(spec string) (*File, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 4 || parts[0] == "" {
+		return nil, fmt.Errorf("Invalid file %s, expected SOURCE:DEST[:OWNER[:MODE]]", spec)
+	}
+
+	file := &File{
+		Source: parts[0],
+		Dest:   parts[1],
+		Owner:  "root:root",
+		Mode:   "0600",
+	}
+	if !strings.HasPrefix(file.Dest, "/") {
+		return nil, fmt.Errorf("Invalid file %s, destination must be absolute", spec)
+	}
+	if len(parts) > 2 && parts[2] != "" {
+		file.Owner = strings.Replace(parts[2], ".", ":", 1)
+	}
+	if len(parts) > 3 && parts[3] != "" {
+		_, err := strconv.ParseUint(parts[3], 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid file %s, mode must be octal", spec)
+		}
+		file.Mode = parts[3]
+	}
+	return file, nil
+}
+
+// uploads returns the files for the upload directory and the extra files,
+// which are installed in place
+func (o *NodeUP) uploads(files map[string][]byte) []*ssh.Upload {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var uploads []*ssh.Upload
+	for _, name := range names {
+		uploads = append(uploads, &ssh.Upload{
+			Path: o.SSHUploadDir + "/" + name,
+			Data: files[name],
+			Mode: uploadMode(name),
+		})
+	}
+	for _, file := range o.Files {
+		mode, _ := strconv.ParseUint(file.Mode, 8, 32)
+		uploads = append(uploads, &ssh.Upload{
+			Path:  file.Dest,
+			Data:  file.Data,
+			Mode:  os.FileMode(mode),
+			Owner: file.Owner,
+			Sudo:  true,
+		})
+	}
+	return uploads
+}
+
+// uploadMode is the mode of a file in the upload directory. Only the files
+// moved into /etc by runCommands are readable by others.
+func uploadMode(name string) os.FileMode {
+	switch name {
+	case "hosts", "interfaces":
+		return 0644
+	}
+	return 0600
+}
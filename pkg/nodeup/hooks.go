@@ -0,0 +1,142 @@
+package nodeup
+
+import (
+	"fmt"
+	"github.com/foxdalas/nodeup/pkg/nodeup_const"
+	"github.com/foxdalas/nodeup/pkg/ssh"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const remoteHookPrefix = "remote:"
+
+// LoadHooks reads -preBootstrapHook and -postBootstrapHook. A hook is a local
+// executable or, with the remote: prefix, a script run with sudo on the host.
+func (o *NodeUP) LoadHooks() error {
+	var err error
+	o.PreHooks, err = loadHooks("pre", o.PreHookSpecs)
+	if err != nil {
+		return err
+	}
+	o.PostHooks, err = loadHooks("post", o.PostHookSpecs)
+	return err
+}
+
+func loadHooks(stage string, specs []string) ([]*Hook, error) {
+	var hooks []*Hook
+	for i, spec := range specs {
+		hook := &Hook{Path: spec}
+		if strings.HasPrefix(spec, remoteHookPrefix) {
+			hook.Path = strings.TrimPrefix(spec, remoteHookPrefix)
+			hook.Remote = true
+		}
+
+		if hook.Remote {
+			data, err := ioutil.ReadFile(hook.Path)
+			if err != nil {
+				return nil, fmt.Errorf("Hook %s: %s", spec, err)
+			}
+			hook.data = data
+			hook.upload = "nodeup-hook-" + stage + "-" + strconv.Itoa(i)
+		} else {
+			path, err := exec.LookPath(hook.Path)
+			if err != nil {
+				return nil, fmt.Errorf("Hook %s: %s", spec, err)
+			}
+			hook.Path = path
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// hookFiles returns remote hook scripts to upload with the bootstrap files
+func (o *NodeUP) hookFiles() map[string][]byte {
+	files := make(map[string][]byte)
+	for _, hook := range append(o.PreHooks, o.PostHooks...) {
+		if hook.Remote {
+			files[hook.upload] = hook.data
+		}
+	}
+	return files
+}
+
+// runHooks runs hooks one by one and stops at the first failure
+func (o *NodeUP) runHooks(hooks []*Hook, host *Host, executor *ssh.Executor, target *nodeup.Target) error {
+	env := hookEnv(target)
+	for _, hook := range hooks {
+		o.Log().Infof("Running hook %s for host %s", hook.Path, host.Hostname)
+		var err error
+		if hook.Remote {
+			err = o.runCommand(executor, host, o.remoteHookCommand(hook, env))
+		} else {
+			cmd := exec.Command(hook.Path)
+			cmd.Env = append(os.Environ(), env...)
+			cmd.Stdout = host.LogFile
+			cmd.Stderr = host.LogFile
+			err = cmd.Run()
+		}
+		if err != nil {
+			return fmt.Errorf("Hook %s: %s", hook.Path, err)
+		}
+	}
+	return nil
+}
+
+func (o *NodeUP) remoteHookCommand(hook *Hook, env []string) string {
+	script := o.SSHUploadDir + "/" + hook.upload
+	var quoted []string
+	for _, e := range env {
+		quoted = append(quoted, shellQuote(e))
+	}
+	return "chmod +x " + script + " && sudo env " + strings.Join(quoted, " ") + " " + script + "; status=$?; rm -f " + script + "; exit $status"
+}
+
+// hookEnv describes the host to hooks
+func hookEnv(target *nodeup.Target) []string {
+	var roles []string
+	for _, item := range target.RunList {
+		if strings.HasPrefix(item, "role[") && strings.HasSuffix(item, "]") {
+			roles = append(roles, item[5:len(item)-1])
+		}
+	}
+
+	env := map[string]string{
+		"NODEUP_HOSTNAME":    target.Hostname,
+		"NODEUP_DOMAIN":      target.Domain,
+		"NODEUP_SERVER_ID":   target.ServerID,
+		"NODEUP_ADDRESS":     target.Address,
+		"NODEUP_ADDRESSES":   strings.Join(target.Addresses, ","),
+		"NODEUP_ROLE":        strings.Join(roles, ","),
+		"NODEUP_RUN_LIST":    strings.Join(target.RunList, ","),
+		"NODEUP_ENVIRONMENT": target.Environment,
+	}
+
+	var vars []string
+	for key, value := range env {
+		vars = append(vars, key+"="+value)
+	}
+	sort.Strings(vars)
+	return vars
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// hookCommands lists hooks for -plan, local hooks are run on this machine
+func (o *NodeUP) hookCommands(hooks []*Hook, target *nodeup.Target) []string {
+	var commands []string
+	for _, hook := range hooks {
+		if hook.Remote {
+			commands = append(commands, o.remoteHookCommand(hook, hookEnv(target)))
+		} else {
+			commands = append(commands, "local: "+hook.Path)
+		}
+	}
+	return commands
+}
@@ -0,0 +1,43 @@
+package nodeup
+
+import (
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// hostKeyCallback verifies host keys of servers created by nodeup. In the
+// console mode the key must match a fingerprint from the console log.
+func (o *NodeUP) hostKeyCallback(host *Host) gossh.HostKeyCallback {
+	switch o.HostKeyCheck {
+	case "console":
+		return o.KnownHosts.Fingerprints(host.Fingerprints)
+	case "tofu":
+		return o.KnownHosts.TrustOnFirstUse()
+	case "strict":
+		return o.KnownHosts.Strict()
+	default:
+		return gossh.InsecureIgnoreHostKey()
+	}
+}
+
+// HostKeyCallback verifies host keys of existing servers: they must be in
+// the known_hosts store unless host key checking is off
+func (o *NodeUP) HostKeyCallback() gossh.HostKeyCallback {
+	if o.HostKeyCheck == "off" {
+		return gossh.InsecureIgnoreHostKey()
+	}
+	return o.KnownHosts.Strict()
+}
+
+// jumpHostKeyCallback verifies host keys of jump hosts. Bastions are usually
+// known already, so ~/.ssh/known_hosts is read as well. With -hostKeyCheck
+// tofu unknown jump hosts are recorded like new servers.
+func (o *NodeUP) jumpHostKeyCallback() gossh.HostKeyCallback {
+	switch o.HostKeyCheck {
+	case "off":
+		return gossh.InsecureIgnoreHostKey()
+	case "tofu":
+		return o.KnownHosts.TrustOnFirstUse(o.UserKnownHosts)
+	default:
+		return o.KnownHosts.Strict(o.UserKnownHosts)
+	}
+}
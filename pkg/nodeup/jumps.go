@@ -0,0 +1,111 @@
+package nodeup
+
+import (
+	"fmt"
+	"github.com/foxdalas/nodeup/pkg/ssh"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const defaultJumpHosts = "default"
+
+// LoadJumpHosts reads jump hosts by environment from the -jumpHosts file.
+// -jumpHost sets the default ones used by environments not in the file.
+func (o *NodeUP) LoadJumpHosts() error {
+	o.JumpHosts = make(map[string][]*JumpHost)
+	if o.JumpHostsPath != "" {
+		data, err := ioutil.ReadFile(o.JumpHostsPath)
+		if err != nil {
+			return err
+		}
+		err = yaml.UnmarshalStrict(data, &o.JumpHosts)
+		if err != nil {
+			return fmt.Errorf("Jump hosts %s: %s", o.JumpHostsPath, err)
+		}
+	}
+
+	if o.JumpHost != "" {
+		jumps, err := parseJumpHosts(o.JumpHost)
+		if err != nil {
+			return err
+		}
+		o.JumpHosts[defaultJumpHosts] = jumps
+	}
+
+	for environment, jumps := range o.JumpHosts {
+		for _, jump := range jumps {
+			if jump.Host == "" {
+				return fmt.Errorf("Jump hosts %s: host is required", environment)
+			}
+			if jump.User == "" {
+				jump.User = o.SSHUser
+			}
+			if jump.Key != "" {
+				// like -sshKey, with a certificate next to the key if present
+				auth, err := ssh.NewAuth([]string{ssh.AuthCert, ssh.AuthKey}, jump.Key, "", os.Getenv(ssh.KeyPassphraseEnv), "")
+				if err != nil {
+					return fmt.Errorf("Jump host %s: %s", jump.Host, err)
+				}
+				jump.auth = auth
+			}
+		}
+	}
+	return nil
+}
+
+// parseJumpHosts parses ProxyJump like user@host[:port],user@host[:port]
+func parseJumpHosts(s string) ([]*JumpHost, error) {
+	var jumps []*JumpHost
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			return nil, fmt.Errorf("Invalid jump host list %s", s)
+		}
+		jump := &JumpHost{Host: item}
+		if i := strings.LastIndex(item, "@"); i >= 0 {
+			jump.User = item[:i]
+			jump.Host = item[i+1:]
+		}
+		jumps = append(jumps, jump)
+	}
+	return jumps, nil
+}
+
+// Jumps returns the jump hosts for servers of the environment
+func (o *NodeUP) Jumps(environment string) []ssh.Jump {
+	jumps, ok := o.JumpHosts[environment]
+	if !ok {
+		jumps = o.JumpHosts[defaultJumpHosts]
+	}
+
+	var result []ssh.Jump
+	for _, jump := range jumps {
+		result = append(result, ssh.Jump{
+			Address:         jump.Host,
+			User:            jump.User,
+			Auth:            jump.auth,
+			HostKeyCallback: o.jumpHostKeyCallback(),
+		})
+	}
+	return result
+}
+
+// ProxyJump returns the jump hosts of the environment in OpenSSH ProxyJump
+// syntax for the ansible provider. ProxyJump can't take a key per jump host,
+// jump hosts with a key are rejected.
+func (o *NodeUP) ProxyJump(environment string) (string, error) {
+	var hops []string
+	jumps, ok := o.JumpHosts[environment]
+	if !ok {
+		jumps = o.JumpHosts[defaultJumpHosts]
+	}
+	for _, jump := range jumps {
+		if jump.Key != "" {
+			return "", fmt.Errorf("Jump host %s has a key, ansible can use jump hosts with the SSH agent only", jump.Host)
+		}
+		hops = append(hops, jump.User+"@"+jump.Host)
+	}
+	return strings.Join(hops, ","), nil
+}
@@ -8,17 +8,16 @@ import (
 	garbler "github.com/michaelbironneau/garbler/lib"
 	"os"
 	"os/signal"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
 
 	"bytes"
-	"fmt"
 	log "github.com/sirupsen/logrus"
 	"net"
 	"os/exec"
 	"text/template"
-	"time"
 )
 
 var _ nodeup.NodeUP = &NodeUP{}
@@ -52,10 +51,6 @@ func (o *NodeUP) Init() {
 		os.Mkdir(o.LogDir, 0775)
 	}
 
-	if o.Count > 1 && !o.isWildcard(o.Name) {
-		o.Log().Panicf("Can't create more one host with not unique name. Please set -count 1")
-	}
-
 	if o.DeleteNodes != "" {
 		exit := 0
 		for _, hostname := range strings.Split(o.DeleteNodes, ",") {
@@ -70,116 +65,277 @@ func (o *NodeUP) Init() {
 			} else {
 				o.Log().Infof("Server %s successfully deleted from openstack", hostname)
 			}
-			_, err = o.Chef.CleanupNode(hostname, hostname)
+			err = o.Bootstrap.Cleanup(&nodeup.Target{Hostname: hostname, ServerID: serverID})
 			if err != nil {
-				o.Log().Errorf("Server %s delete problem chef", hostname)
+				o.Log().Errorf("Server %s delete problem %s", hostname, o.BootstrapProvider)
 				o.Log().Error(err)
 				exit = 1
 			} else {
-				o.Log().Infof("Server %s successfully deleted from chef", hostname)
+				o.Log().Infof("Server %s successfully deleted from %s", hostname, o.BootstrapProvider)
 			}
 		}
 		os.Exit(exit)
 	}
 
-	var wg sync.WaitGroup
-	for _, hostname := range o.nameGenerator(o.Name, o.Count) {
-		o.Log().Debugf("Starting goroutine for host %s", hostname)
-		wg.Add(1)
-		go func(hostname string) {
-			if !o.bootstrapHost(o.Openstack, o.Chef, hostname, &wg) {
-				o.Exitcode = 1
-			}
-		}(hostname)
+	if o.PlanMode {
+		os.Exit(o.plan(os.Stdout))
+	}
+
+	for _, group := range o.Groups {
+		var err error
+		group.resolved, err = o.Openstack.Resolve(group.Flavor, group.Image, group.ImageProperties, strings.Join(group.Networks, ","))
+		if err != nil {
+			o.Log().Errorf("Group %s: %s", group.Name, err)
+			os.Exit(1)
+		}
+		err = o.checkPolicy(group)
+		if err != nil {
+			o.Log().Errorf("Group %s: %s", group.Name, err)
+			os.Exit(1)
+		}
+	}
+
+	var hosts []*Host
+	for _, group := range o.Groups {
+		o.Log().Infof("Group %s: %d hosts with flavor %s", group.Name, group.Count, group.Flavor)
+		for _, hostname := range o.nameGenerator(group.Name, group.Count) {
+			hosts = append(hosts, &Host{Hostname: hostname, Group: group})
+		}
+	}
+
+	queue := make(chan *Host, len(hosts))
+	for _, host := range hosts {
+		queue <- host
+	}
+	close(queue)
+
+	created := o.phase("create", queue, o.createHost)
+	var done <-chan *Host
+	if o.CloudInit {
+		done = o.phase("cloud-init", created, o.waitCloudInit)
+	} else {
+		ready := o.phase("ssh", created, o.waitHost)
+		done = o.phase("bootstrap", ready, o.bootstrapHost)
+	}
+
+	succeeded := 0
+	for range done {
+		succeeded++
+	}
+	o.Log().Infof("Bootstrapped %d of %d hosts", succeeded, len(hosts))
+	if o.report(hosts) > 0 || succeeded != len(hosts) {
+		o.Exitcode = 1
 	}
-	o.Log().Debug("Waiting for workers to finish")
-	wg.Wait()
 	os.Exit(o.Exitcode)
 }
 
-func (o *NodeUP) bootstrapHost(s *openstack.Openstack, c *chef.ChefClient, hostname string, wg *sync.WaitGroup) bool {
-	defer wg.Done()
+// createHost creates the OpenStack server and the per-host log file.
+func (o *NodeUP) createHost(host *Host) bool {
+	group := host.Group
+	var userData []byte
+	if o.CloudInit {
+		var err error
+		userData, err = o.userData(host)
+		if err != nil {
+			o.Log().Errorf("Host %s: %s", host.Hostname, err)
+			host.Err = err
+			o.Bootstrap.Cleanup(o.target(host))
+			return false
+		}
+	}
 
-	oHost, err := s.CreateSever(hostname, o.OSGroupID, o.DefineNetworks, o.AvailabilityZone)
+	oHost, err := o.Openstack.CreateSever(host.Hostname, group.resolved, group.ServerGroup, group.AvailabilityZone, userData)
 	if err != nil {
+		host.Err = err
+		if o.CloudInit {
+			o.Bootstrap.Cleanup(o.target(host))
+		}
 		return false
 	}
+	host.Server = oHost
 
-	logFile := o.LogDir + "/" + hostname + ".log"
-	outFile, err := os.Create(logFile)
+	logFile := o.LogDir + "/" + host.Hostname + ".log"
+	host.LogFile, err = os.Create(logFile)
 	if err != nil {
+		o.Log().Errorf("Can't create log file %s: %s", logFile, err)
+		host.Err = err
+		o.Openstack.DeleteServer(oHost.ID)
 		return false
 	}
 	if o.JenkinsMode {
-		o.Log().Infof("Processing log %s%s.log", o.JenkinsLogURL, hostname)
+		o.Log().Infof("Processing log %s%s.log", o.JenkinsLogURL, host.Hostname)
 	}
+	return true
+}
 
-	var availableAddresses []string
+// waitHost waits until every address of the host passes the readiness probe.
+func (o *NodeUP) waitHost(host *Host) bool {
+	ipAddresses := o.GetAddress(host.Server.Addresses)
+	o.Log().Debugf("Ip Addresses for host %s: %s", host.Hostname, strings.Join(ipAddresses, ","))
+	for i, ip := range ipAddresses {
+		// the host is bootstrapped over the first address, only TCP is checked
+		// for the others
+		err := o.probeHost(host, ip, i == 0)
+		if err != nil {
+			o.Log().Errorf("Host %s is not ready: %s", host.Hostname, err)
+			host.Err = err
+			o.Openstack.DeleteServer(host.Server.ID)
+			host.LogFile.Close()
+			return false
+		}
+		o.Log().Debugf("Host %s is ready on %s", host.Hostname, ip)
+		host.Addresses = append(host.Addresses, ip)
+	}
 
-	ipAddresses := o.GetAddress(oHost.Addresses)
-	o.Log().Debugf("Ip Addresses for host %s: %s", hostname, strings.Join(ipAddresses, ","))
-	for _, ip := range ipAddresses {
+	if len(host.Addresses) == 0 {
+		o.Log().Errorf("Can't bootstrap host %s no SSH access", host.Hostname)
+		o.Openstack.DeleteServer(host.Server.ID)
+		host.LogFile.Close()
+		return false
+	}
+	return true
+}
 
-		if o.checkSSHPort(ip) {
-			o.Log().Debugf("SSH is accessible on host %s", hostname)
-			availableAddresses = append(availableAddresses, ip)
-		} else {
-			o.Log().Errorf("SSH is unreachable on host %s", hostname)
-			s.DeleteServer(oHost.ID)
-			return false
+func (o *NodeUP) bootstrapHost(host *Host) bool {
+	defer host.LogFile.Close()
+
+	//Create SSH connection
+	sshClient, err := ssh.New(o, host.Addresses[0], o.SSHUser, o.SSHAuth, o.hostKeyCallback(host), o.Jumps(host.Group.ChefEnvironment))
+	if o.assertBootstrap(host, err) {
+		return false
+	}
+	executor := ssh.NewExecutor(sshClient, o.CommandTimeout, o.BootstrapTimeout)
+	defer executor.Close()
+
+	host.Family = o.detectFamily(executor, host.Group.resolved.Distro)
+	target := o.target(host)
+
+	//Create Bootstrap data
+	files, err := o.Bootstrap.Prepare(target)
+	// without the bootstrap data there is nothing to go on, even with -ignoreFail
+	if o.assertBootstrap(host, err) || err != nil {
+		return false
+	}
+	files["hosts"] = o.createHostsFile(host.Hostname, o.Domain)
+	for name, data := range o.hookFiles() {
+		files[name] = data
+	}
+	if o.UsePrivateNetwork && host.Family.Interfaces {
+		files["interfaces"] = o.createInterfacesFile(o.Gateway)
+	}
+
+	o.Log().Infof("Bootstrapping host %s", host.Hostname)
+	//Upload files via ssh
+	err = executor.Upload(o.uploads(files), o.SSHUploadDir)
+	if o.assertBootstrap(host, err) {
+		return false
+	}
+
+	if o.UsePrivateNetwork {
+		for _, command := range o.configureDefaultGateway(host.Family) {
+			err = o.runCommand(executor, host, command)
+			if o.assertBootstrap(host, err) {
+				return false
+			}
 		}
+	}
 
-		if len(availableAddresses) == 0 {
-			o.Log().Errorf("Can't bootstrap host %s no SSH access", hostname)
-			s.DeleteServer(oHost.ID)
+	//Run command via ssh
+	for _, command := range o.runCommands(host.Hostname, host.Family) {
+		err = o.runCommand(executor, host, command)
+		if o.assertBootstrap(host, err) {
 			return false
 		}
+	}
+
+	err = o.runHooks(o.PreHooks, host, executor, target)
+	if o.assertBootstrap(host, err) {
+		return false
+	}
 
-		//Create SSH connection
-		sshClient, err := ssh.New(o, ip, "cloud-user")
-		if o.assertBootstrap(s, c, oHost.ID, hostname, err) {
+	for _, command := range o.Bootstrap.Commands(target) {
+		err = o.runCommand(executor, host, command)
+		if o.assertBootstrap(host, err) {
 			return false
 		}
+	}
 
-		//Create Bootstrap data
-		chefData, err := chef.New(o, hostname, o.Domain, o.ChefServerUrl, o.ChefValidationPem, o.ChefValidationPath, []string{"role[" + o.ChefRole + "]"})
-		if o.assertBootstrap(s, c, oHost.ID, hostname, err) {
+	if runner, ok := o.Bootstrap.(nodeup.Runner); ok {
+		err = runner.Run(target)
+		if o.assertBootstrap(host, err) {
 			return false
 		}
+	}
 
-		o.Log().Infof("Bootstrapping host %s", hostname)
-		//Upload files via ssh
-		for fileName, fileData := range o.transferFiles(chefData) {
-			err = sshClient.TransferFile(fileData, fileName, o.SSHUploadDir)
-			if o.assertBootstrap(s, c, oHost.ID, hostname, err) {
-				return false
-			}
-		}
+	err = o.Bootstrap.Verify(target)
+	if o.assertBootstrap(host, err) {
+		return false
+	}
 
-		if o.UsePrivateNetwork {
-			err = sshClient.TransferFile(o.createInterfacesFile(o.Gateway), "interfaces", o.SSHUploadDir)
-			if o.assertBootstrap(s, c, oHost.ID, hostname, err) {
-				return false
-			}
-			for _, command := range o.configureDefaultGateway() {
-				err = sshClient.RunCommandPipe(command, outFile)
-				if o.assertBootstrap(s, c, oHost.ID, hostname, err) {
-					return false
-				}
-			}
-		}
+	err = o.runChecks(host, executor)
+	if o.assertBootstrap(host, err) {
+		return false
+	}
 
-		//Run command via ssh
-		for _, command := range o.runCommands(o.SSHUploadDir, o.ChefVersion, o.ChefEnvironment) {
-			err = sshClient.RunCommandPipe(command, outFile)
-			if o.assertBootstrap(s, c, oHost.ID, hostname, err) {
-				return false
-			}
-		}
+	err = o.runHooks(o.PostHooks, host, executor, target)
+	if o.assertBootstrap(host, err) {
+		return false
 	}
 	return true
 }
 
+// runCommand runs command on the host with the output in the host log
+func (o *NodeUP) runCommand(executor *ssh.Executor, host *Host, command string) error {
+	result, err := executor.Run(command, host.LogFile)
+	if err != nil {
+		o.Log().Errorf("Host %s command failed after %s: %s", host.Hostname, result.Duration, err)
+		return err
+	}
+	o.Log().Debugf("Host %s command finished in %s", host.Hostname, result.Duration)
+	return nil
+}
+
+// tags mark nodes created by nodeup, by whom and from which build
+func (o *NodeUP) tags() []string {
+	tags := []string{"nodeup"}
+	if o.Creator != "" {
+		tags = append(tags, "nodeup:creator="+o.Creator)
+	}
+	if o.BuildURL != "" {
+		tags = append(tags, "nodeup:build="+o.BuildURL)
+	}
+	return tags
+}
+
+// target describes the host for the bootstrap provider
+func (o *NodeUP) target(host *Host) *nodeup.Target {
+	target := &nodeup.Target{
+		Hostname:    host.Hostname,
+		Domain:      o.Domain,
+		Addresses:   host.Addresses,
+		UploadDir:   o.SSHUploadDir,
+		Environment: host.Group.ChefEnvironment,
+		RunList:     host.Group.RunList,
+		PolicyName:  host.Group.PolicyName,
+		PolicyGroup: host.Group.PolicyGroup,
+		Attributes:  host.Group.Attributes,
+		Tags:        o.tags(),
+		Log:         host.LogFile,
+	}
+	if len(host.Addresses) > 0 {
+		target.Address = host.Addresses[0]
+	}
+	if host.Server != nil {
+		target.ServerID = host.Server.ID
+		target.ServerAddresses = serverAddresses(host.Server.Addresses)
+		target.Metadata = host.Server.Metadata
+	}
+	if host.Family != nil {
+		target.Family = host.Family.Name
+	}
+	return target
+}
+
 func (o *NodeUP) Stop() {
 	o.Log().Info("shutting things down")
 	close(o.StopCh)
@@ -194,6 +350,19 @@ func (o *NodeUP) Version() string {
 	return o.Ver
 }
 
+// ChefEnabled reports whether hosts are registered on the Chef server
+func (o *NodeUP) ChefEnabled() bool {
+	return o.BootstrapProvider == "chef"
+}
+
+// checkPolicy makes sure the group policy is pushed to the Chef server
+func (o *NodeUP) checkPolicy(group *Group) error {
+	if group.PolicyName == "" || o.Chef == nil {
+		return nil
+	}
+	return o.Chef.CheckPolicy(group.PolicyName, group.PolicyGroup)
+}
+
 func (o *NodeUP) nameGenerator(prefix string, count int) []string {
 
 	o.Log().Debugf("Generation hostname for %d hosts", count)
@@ -254,31 +423,17 @@ func (o *NodeUP) GetAddress(addresses map[string]interface{}) []string {
 	}
 }
 
-func sshConnect(address string) error {
-	conn, err := net.DialTimeout("tcp", address+":22", 5*time.Second)
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-	return err
-}
-
-func (o *NodeUP) checkSSHPort(address string) bool {
-	o.Log().Infof("Waiting SSH on host %s", address)
-	time.Sleep(10 * time.Second) //Waiting ssh daemon
-	for i := 0; i <= o.SSHWaitRetry; i++ {
-		err := sshConnect(address)
-		if err != nil {
-			o.Log().Warnf("Cannot connect to host %s #%d: %s", address, i+1, err.Error())
-		} else {
-			return true
+// serverAddresses returns every fixed and floating address of a server,
+// unlike GetAddress which picks the ones to connect to
+func serverAddresses(addresses map[string]interface{}) []string {
+	var result []string
+	for _, networks := range addresses {
+		for _, addrs := range networks.([]interface{}) {
+			result = append(result, addrs.(map[string]interface{})["addr"].(string))
 		}
-		o.Log().Infof("Retries left %d", o.SSHWaitRetry-i)
-		time.Sleep(10 * time.Second)
 	}
-	o.Log().Errorf("Can't connect to host %s via ssh", address)
-
-	return false
+	sort.Strings(result)
+	return result
 }
 
 func (o *NodeUP) deleteChefNode(hostname string) {
@@ -307,26 +462,29 @@ func (o *NodeUP) isWildcard(string string) bool {
 	}
 }
 
-func (o *NodeUP) assertBootstrap(openstack *openstack.Openstack, chefClient *chef.ChefClient, id string, hostname string, err error) (exit bool) {
+func (o *NodeUP) assertBootstrap(host *Host, err error) (exit bool) {
+	if err != nil {
+		host.Err = err
+	}
 	if o.IgnoreFail {
-		o.Log().Warnf("Host %s bootstrap is fail. Skied", hostname)
+		if err != nil {
+			o.Log().Warnf("Host %s bootstrap is fail. Skied", host.Hostname)
+		}
 		return false
 	}
 
 	if err != nil {
 		o.Log().Errorf("Bootstrap error: %s", err)
-		host := openstack.DeleteIfError(id, err)
-		chefClient, err := chefClient.CleanupNode(hostname, hostname)
+		deleted := o.Openstack.DeleteIfError(host.Server.ID, err)
+		err = o.Bootstrap.Cleanup(o.target(host))
 		if err != nil {
-			o.Log().Errorf("Chef cleanup node error %s", err)
+			o.Log().Errorf("Bootstrap cleanup error %s", err)
 			o.Exitcode = 1
 			return true
 		}
 
-		if !host && !chefClient {
-			o.Log().Errorf("Can't cleanup node %s", hostname)
-			o.Exitcode = 1
-			return true
+		if !deleted {
+			o.Log().Errorf("Can't cleanup node %s", host.Hostname)
 		}
 		o.Exitcode = 1
 		return true
@@ -345,36 +503,12 @@ func (o *NodeUP) deleteHost(openstack *openstack.Openstack, chefClient *chef.Che
 	}
 }
 
-func (o *NodeUP) configureDefaultGateway() []string {
-	data := []string{
-		"sudo mv interfaces /etc/network/",
-		fmt.Sprintf("sudo route add default gw %s", o.Gateway),
-	}
-	return data
-}
-
-func (o *NodeUP) transferFiles(chef *chef.Chef) map[string][]byte {
-	data := make(map[string][]byte)
-	data["bootstrap.json"] = chef.BootstrapJson
-	data["validation.pem"] = chef.ValidationPem
-	data["client.rb"] = chef.ChefConfig
-	data["hosts"] = chef.Hosts
-
-	return data
+func (o *NodeUP) configureDefaultGateway(family *Family) []string {
+	return o.renderCommands(family.Gateway, &CommandData{Gateway: o.Gateway, UploadDir: o.SSHUploadDir})
 }
 
-func (o *NodeUP) runCommands(dir string, version string, environment string) []string {
-	data := []string{
-		"sudo mv hosts /etc/hosts && sudo hostname -F /etc/hostname",
-		"sudo apt-get update",
-		"sudo mkdir /etc/chef",
-		"wget -q https://omnitruck.chef.io/install.sh && sudo bash ./install.sh -v " + version + " && rm install.sh",
-		"sudo chmod 0600 " + dir + "/validation.pem",
-		"sudo chef-client -c " + dir + "/client.rb -E " + environment + " -j " + dir + "/bootstrap.json",
-		"sudo rm " + dir + "/client.rb && sudo rm " + dir + "/validation.pem && rm " + dir + "/bootstrap.json",
-		"sudo chef-client",
-	}
-	return data
+func (o *NodeUP) runCommands(hostname string, family *Family) []string {
+	return o.renderCommands([]string{family.Hostname, family.Update}, &CommandData{Hostname: hostname, UploadDir: o.SSHUploadDir})
 }
 
 func contains(slice []string, item string) bool {
@@ -422,6 +556,27 @@ func (o *NodeUP) publicIP(ip string) bool {
 	return false
 }
 
+func (o *NodeUP) createHostsFile(hostname string, domain string) []byte {
+	hosts := &Hosts{
+		Hostname: hostname,
+		Domain:   domain,
+	}
+
+	var buf bytes.Buffer
+	t := template.New("hosts")
+	t, err := t.Parse(`
+127.0.0.1       localhost
+127.0.1.1       {{ .Hostname }}.{{ .Domain }} {{ .Hostname }}`)
+	if err != nil {
+		o.Log().Fatal(err)
+	}
+	err = t.Execute(&buf, hosts)
+	if err != nil {
+		o.Log().Fatal(err)
+	}
+	return buf.Bytes()
+}
+
 func (o *NodeUP) createInterfacesFile(gateway string) []byte {
 	interfaces := &Interfaces{
 		Gateway: gateway,
@@ -0,0 +1,331 @@
+package nodeup
+
+import (
+	"github.com/foxdalas/nodeup/pkg/nodeup_const"
+	"github.com/foxdalas/nodeup/pkg/ssh"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateHostsFile(t *testing.T) {
+	o := &NodeUP{}
+	r := o.createHostsFile("test", "hostname.example.com")
+
+	testData := `
+127.0.0.1       localhost
+127.0.1.1       test.hostname.example.com test`
+	assert.Equal(t, testData, string(r))
+}
+
+func TestApplyAttributes(t *testing.T) {
+	o := &NodeUP{
+		ChefAttrs: []string{"mongodb.shard=3", "mongodb.replica_set=rs3", "ntp.servers=[\"ntp1\",\"ntp2\"]"},
+		Groups: []*Group{
+			{
+				Name: "db",
+				Attributes: map[string]interface{}{
+					"mongodb": map[interface{}]interface{}{
+						"shard":  1,
+						"engine": "wiredTiger",
+					},
+				},
+			},
+		},
+	}
+	err := o.applyAttributes()
+	assert.Equal(t, nil, err)
+
+	testData := map[string]interface{}{
+		"mongodb": map[string]interface{}{
+			"shard":       float64(3),
+			"replica_set": "rs3",
+			"engine":      "wiredTiger",
+		},
+		"ntp": map[string]interface{}{
+			"servers": []interface{}{"ntp1", "ntp2"},
+		},
+	}
+	assert.Equal(t, testData, o.Groups[0].Attributes)
+}
+
+func TestLoadFiles(t *testing.T) {
+	os.Setenv("NODEUP_TEST_SECRET", "secret")
+	defer os.Unsetenv("NODEUP_TEST_SECRET")
+
+	o := &NodeUP{
+		SSHUploadDir: "/home/cloud-user",
+		FileSpecs: []string{
+			"$NODEUP_TEST_SECRET:/etc/chef/encrypted_data_bag_secret",
+			"$NODEUP_TEST_SECRET:/etc/chef/trusted_certs/ca.crt:root.adm:0644",
+		},
+	}
+	assert.Equal(t, nil, o.LoadFiles())
+	assert.Equal(t, []byte("secret"), o.Files[0].Data)
+	assert.Equal(t, "0600", o.Files[0].Mode)
+
+	uploads := o.uploads(map[string][]byte{"hosts": []byte("127.0.0.1 localhost\n"), "validation.pem": []byte("key")})
+	assert.Len(t, uploads, 4)
+	assert.Equal(t, "/home/cloud-user/hosts", uploads[0].Path)
+	assert.Equal(t, os.FileMode(0644), uploads[0].Mode)
+	assert.Equal(t, os.FileMode(0600), uploads[1].Mode)
+	assert.False(t, uploads[1].Sudo)
+	assert.Equal(t, "/etc/chef/trusted_certs/ca.crt", uploads[3].Path)
+	assert.Equal(t, os.FileMode(0644), uploads[3].Mode)
+	assert.Equal(t, "root:adm", uploads[3].Owner)
+	assert.True(t, uploads[3].Sudo)
+
+	dir, err := ioutil.TempDir("", "nodeup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "certs"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "key.pem"), []byte("key"), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "certs", "ca.crt"), []byte("ca"), 0644))
+	o.FileSpecs = []string{dir + ":/etc/nginx/ssl:root.www-data:0640"}
+	assert.NoError(t, o.LoadFiles())
+	assert.Len(t, o.Files, 2)
+	assert.Equal(t, "/etc/nginx/ssl/certs/ca.crt", o.Files[0].Dest)
+	assert.Equal(t, "/etc/nginx/ssl/key.pem", o.Files[1].Dest)
+	assert.Equal(t, "0640", o.Files[1].Mode)
+
+	o.FileSpecs = []string{"$NODEUP_TEST_MISSING:/etc/secret"}
+	assert.NotNil(t, o.LoadFiles())
+
+	o.FileSpecs = []string{"secret:etc/secret"}
+	assert.NotNil(t, o.LoadFiles())
+
+	o.FileSpecs = []string{"secret:/etc/secret:root:rw"}
+	assert.NotNil(t, o.LoadFiles())
+}
+
+func TestRemoteHookCommand(t *testing.T) {
+	o := &NodeUP{SSHUploadDir: "/home/cloud-user"}
+	target := &nodeup.Target{
+		Hostname:    "app-1",
+		Domain:      "example.com",
+		ServerID:    "id",
+		Address:     "10.0.0.1",
+		Addresses:   []string{"10.0.0.1", "192.168.0.1"},
+		Environment: "production",
+		RunList:     []string{"role[base]", "role[app]", "recipe[nginx]"},
+	}
+	hook := &Hook{Path: "mount.sh", Remote: true, upload: "nodeup-hook-pre-0"}
+
+	assert.Equal(t, "chmod +x /home/cloud-user/nodeup-hook-pre-0 && sudo env "+
+		"'NODEUP_ADDRESS=10.0.0.1' 'NODEUP_ADDRESSES=10.0.0.1,192.168.0.1' 'NODEUP_DOMAIN=example.com' "+
+		"'NODEUP_ENVIRONMENT=production' 'NODEUP_HOSTNAME=app-1' 'NODEUP_ROLE=base,app' "+
+		"'NODEUP_RUN_LIST=role[base],role[app],recipe[nginx]' 'NODEUP_SERVER_ID=id' "+
+		"/home/cloud-user/nodeup-hook-pre-0; status=$?; rm -f /home/cloud-user/nodeup-hook-pre-0; exit $status",
+		o.remoteHookCommand(hook, hookEnv(target)))
+}
+
+func TestHostChecks(t *testing.T) {
+	o := &NodeUP{
+		Checks: &Checks{Roles: map[string][]*Check{
+			"*":   {{Port: 22}},
+			"app": {{Unit: "nginx"}, {HTTP: "http://localhost/health"}},
+			"db":  {{File: "/etc/mysql/my.cnf"}},
+		}},
+	}
+	host := &Host{Group: &Group{
+		RunList: []string{"role[base]", "role[app]"},
+		Checks:  []*Check{{File: "/etc/nginx/nginx.conf"}},
+	}}
+
+	var commands []string
+	for _, check := range o.hostChecks(host) {
+		command, err := check.command()
+		assert.Equal(t, nil, err)
+		commands = append(commands, command)
+	}
+	assert.Equal(t, []string{
+		"(ss -ltn 2>/dev/null || netstat -ltn) | awk '{print $4}' | grep -Eq '[:.]22$'",
+		"systemctl is-active --quiet 'nginx'",
+		"test \"$(curl -sS -o /dev/null -w '%{http_code}' 'http://localhost/health')\" = 200",
+		"sudo test -e '/etc/nginx/nginx.conf'",
+	}, commands)
+
+	_, err := (&Check{Port: 80, Unit: "nginx"}).command()
+	assert.NotNil(t, err)
+	_, err = (&Check{}).command()
+	assert.NotNil(t, err)
+}
+
+func TestRenderUserData(t *testing.T) {
+	files := map[string][]byte{
+		"hosts":     []byte("127.0.0.1 localhost\n"),
+		"client.rb": []byte("node_name \"test\""),
+	}
+	commands := []string{"sudo hostname -F /etc/hostname", "echo 'done'"}
+
+	testData := `#!/bin/bash
+notify() { echo "$1"; echo "$1" > /dev/console 2>/dev/null; }
+run() { bash -c "$1" || { notify 'nodeup: bootstrap failed'; exit 1; }; }
+sudo() { "$@"; }
+export -f sudo
+mkdir -p /home/cloud-user
+cd /home/cloud-user
+cleanup() { rm -f /home/cloud-user/client.rb /home/cloud-user/hosts /var/lib/cloud/instance/user-data.txt /var/lib/cloud/instance/user-data.txt.i /var/lib/cloud/instance/scripts/part-*; }
+trap cleanup EXIT
+(umask 177; base64 -d > /home/cloud-user/client.rb) <<'NODEUP_EOF'
+bm9kZV9uYW1lICJ0ZXN0Ig==
+NODEUP_EOF
+(umask 133; base64 -d > /home/cloud-user/hosts) <<'NODEUP_EOF'
+MTI3LjAuMC4xIGxvY2FsaG9zdAo=
+NODEUP_EOF
+run 'sudo hostname -F /etc/hostname'
+run 'echo '\''done'\'''
+notify 'nodeup: bootstrap finished'
+`
+	assert.Equal(t, testData, string(renderUserData("/home/cloud-user", files, commands)))
+}
+
+func TestRenderUserDataRun(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+	dir, err := ioutil.TempDir("", "nodeup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	upload := filepath.Join(dir, "home")
+
+	// the family commands use absolute upload directory paths
+	o := &NodeUP{SSHUploadDir: upload}
+	hostname := o.runCommands("test", families["debian"])[0]
+	assert.True(t, strings.HasPrefix(hostname, "sudo mv "+upload+"/hosts "))
+	gateway := o.configureDefaultGateway(families["debian"])[0]
+	assert.Equal(t, "sudo mv "+upload+"/interfaces /etc/network/", gateway)
+
+	files := map[string][]byte{
+		"hosts":     []byte("127.0.0.1 localhost\n"),
+		"client.rb": []byte("node_name \"test\""),
+	}
+	commands := []string{
+		"mv " + upload + "/hosts " + dir + "/hosts",
+		"test -f client.rb",
+		"test \"$(stat -c %a client.rb)\" = 600",
+	}
+	script := string(renderUserData(upload, files, commands))
+	// keep the cloud-init copies of the build machine
+	copies := filepath.Join(dir, "user-data.txt")
+	assert.NoError(t, ioutil.WriteFile(copies, []byte(script), 0600))
+	script = strings.Replace(script, cloudInitCopies, copies, 1)
+
+	cmd := exec.Command(bash, "-c", script)
+	cmd.Dir = "/"
+	out, err := cmd.CombinedOutput()
+	assert.NoError(t, err, string(out))
+	assert.Contains(t, string(out), cloudInitFinished)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "hosts"))
+	assert.NoError(t, err)
+	assert.Equal(t, files["hosts"], data)
+	_, err = os.Stat(filepath.Join(upload, "client.rb"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(copies)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestLoadJumpHosts(t *testing.T) {
+	o := &NodeUP{SSHUser: "ubuntu", JumpHost: "admin@bastion:2222, 10.0.0.5"}
+	err := o.LoadJumpHosts()
+	assert.NoError(t, err)
+	assert.Equal(t, []*JumpHost{
+		{Host: "bastion:2222", User: "admin"},
+		{Host: "10.0.0.5", User: "ubuntu"},
+	}, o.JumpHosts["default"])
+
+	jumps := o.Jumps("production")
+	assert.Len(t, jumps, 2)
+	assert.Equal(t, "admin", jumps[0].User)
+	assert.Equal(t, "bastion:2222", jumps[0].Address)
+
+	proxyJump, err := o.ProxyJump("production")
+	assert.NoError(t, err)
+	assert.Equal(t, "admin@bastion:2222,ubuntu@10.0.0.5", proxyJump)
+	o.JumpHosts["default"][0].Key = "/keys/bastion"
+	_, err = o.ProxyJump("production")
+	assert.Error(t, err)
+
+	o.JumpHost = "admin@bastion,"
+	assert.Error(t, o.LoadJumpHosts())
+}
+
+func TestJumpHostKeyCallback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodeup-jump")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	known, err := ssh.NewKnownHosts(filepath.Join(dir, "nodeup", "known_hosts"))
+	assert.NoError(t, err)
+	key, _, _, _, err := gossh.ParseAuthorizedKey([]byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIP9pesIzyAYXqinbjqVoHJIKPTWRR+pFu8f+diKCKgGD root@test"))
+	assert.NoError(t, err)
+	addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+
+	// an unknown bastion is rejected by strict and recorded by tofu
+	o := &NodeUP{HostKeyCheck: "strict", KnownHosts: known, UserKnownHosts: filepath.Join(dir, "missing")}
+	assert.Error(t, o.jumpHostKeyCallback()("bastion:22", addr, key))
+	o.HostKeyCheck = "tofu"
+	assert.NoError(t, o.jumpHostKeyCallback()("bastion:22", addr, key))
+	o.HostKeyCheck = "strict"
+	assert.NoError(t, o.jumpHostKeyCallback()("bastion:22", addr, key))
+
+	// bastions in the user's known_hosts are accepted
+	user := filepath.Join(dir, "known_hosts")
+	assert.NoError(t, ioutil.WriteFile(user, []byte(knownhosts.Line([]string{"bastion2"}, key)+"\n"), 0600))
+	o.UserKnownHosts = user
+	assert.NoError(t, o.jumpHostKeyCallback()("bastion2:22", addr, key))
+}
+
+func TestServerAddresses(t *testing.T) {
+	addresses := map[string]interface{}{
+		"private": []interface{}{
+			map[string]interface{}{"addr": "10.0.0.5", "OS-EXT-IPS:type": "fixed"},
+			map[string]interface{}{"addr": "203.0.113.5", "OS-EXT-IPS:type": "floating"},
+		},
+	}
+	assert.Equal(t, []string{"10.0.0.5", "203.0.113.5"}, serverAddresses(addresses))
+}
+
+type testCloser struct {
+	closed chan struct{}
+}
+
+func (c *testCloser) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestProbeCloseLate(t *testing.T) {
+	o := &NodeUP{Logging: log.NewEntry(log.New())}
+	conn := &testCloser{closed: make(chan struct{})}
+	result, err := o.probe("ssh", "10.0.0.5", 50*time.Millisecond, func() (io.Closer, error) {
+		time.Sleep(100 * time.Millisecond)
+		return conn, nil
+	})
+	assert.Error(t, err)
+	assert.Nil(t, result)
+
+	select {
+	case <-conn.closed:
+	case <-time.After(time.Second):
+		t.Error("late connection is not closed")
+	}
+
+	result, err = o.probe("ssh", "10.0.0.5", time.Second, func() (io.Closer, error) {
+		return conn, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, conn, result)
+}
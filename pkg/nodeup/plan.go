@@ -0,0 +1,147 @@
+package nodeup
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/foxdalas/nodeup/pkg/nodeup_const"
+	"io"
+	"strings"
+)
+
+// plan resolves every host of every group without creating anything and
+// prints the result. It returns the exit code: 1 if any host can't be created.
+func (o *NodeUP) plan(w io.Writer) int {
+	plan := &Plan{}
+	seen := make(map[string]bool)
+
+	for _, group := range o.Groups {
+		networks := strings.Join(group.Networks, ",")
+		resolved, err := o.Openstack.Resolve(group.Flavor, group.Image, group.ImageProperties, networks)
+		distro := ""
+		if err == nil {
+			distro = resolved.Distro
+		}
+		policyErr := o.checkPolicy(group)
+
+		for _, hostname := range o.nameGenerator(group.Name, group.Count) {
+			family := familyByDistro(distro)
+			target := o.target(&Host{Hostname: hostname, Group: group, Family: family})
+
+			host := &PlanHost{
+				Hostname:         hostname,
+				Group:            group.Name,
+				Flavor:           group.Flavor,
+				Networks:         group.Networks,
+				AvailabilityZone: group.AvailabilityZone,
+				ServerGroup:      group.ServerGroup,
+				ChefEnvironment:  group.ChefEnvironment,
+				RunList:          group.RunList,
+				PolicyName:       group.PolicyName,
+				PolicyGroup:      group.PolicyGroup,
+				Attributes:       group.Attributes,
+				Commands:         o.planCommands(hostname, family, target),
+			}
+			if err != nil {
+				host.Errors = append(host.Errors, err.Error())
+			} else {
+				host.Resolved = resolved
+			}
+			if policyErr != nil {
+				host.Errors = append(host.Errors, policyErr.Error())
+			}
+
+			if seen[hostname] {
+				host.Errors = append(host.Errors, "hostname is used twice in this plan")
+			}
+			seen[hostname] = true
+			if o.Openstack.IsServerExist(hostname) {
+				host.Errors = append(host.Errors, "server already exists in openstack")
+			}
+			if o.Chef != nil && o.Chef.IsNodeExist(hostname) {
+				host.Errors = append(host.Errors, "node already exists in chef")
+			}
+			if o.Chef != nil && o.Chef.IsClientExist(hostname) {
+				host.Errors = append(host.Errors, "client already exists in chef")
+			}
+
+			if len(host.Errors) > 0 {
+				plan.Failed++
+			}
+			plan.Hosts = append(plan.Hosts, host)
+		}
+	}
+
+	var err error
+	if o.PlanFormat == "json" {
+		err = o.writePlanJson(w, plan)
+	} else {
+		err = o.writePlanText(w, plan)
+	}
+	if err != nil {
+		o.Log().Errorf("Can't write plan: %s", err)
+		return 1
+	}
+
+	if plan.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func (o *NodeUP) writePlanJson(w io.Writer, plan *Plan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+func (o *NodeUP) writePlanText(w io.Writer, plan *Plan) error {
+	for _, host := range plan.Hosts {
+		status := "create"
+		if len(host.Errors) > 0 {
+			status = "error"
+		}
+		fmt.Fprintf(w, "%s %s (group %s)\n", status, host.Hostname, host.Group)
+		if host.Resolved != nil {
+			fmt.Fprintf(w, "  flavor:      %s (%s)\n", host.Flavor, host.Resolved.FlavorID)
+			fmt.Fprintf(w, "  image:       %s (%s, distro %s)\n", host.Resolved.ImageName, host.Resolved.ImageID, host.Resolved.Distro)
+			fmt.Fprintf(w, "  networks:    %s (%s)\n", strings.Join(host.Networks, ","), strings.Join(host.Resolved.NetworkIDs, ","))
+		} else {
+			fmt.Fprintf(w, "  flavor:      %s\n", host.Flavor)
+			fmt.Fprintf(w, "  networks:    %s\n", strings.Join(host.Networks, ","))
+		}
+		if host.AvailabilityZone != "" {
+			fmt.Fprintf(w, "  zone:        %s\n", host.AvailabilityZone)
+		}
+		if host.ServerGroup != "" {
+			fmt.Fprintf(w, "  group:       %s\n", host.ServerGroup)
+		}
+		if host.PolicyName != "" {
+			fmt.Fprintf(w, "  policy:      %s (%s)\n", host.PolicyName, host.PolicyGroup)
+		} else {
+			fmt.Fprintf(w, "  environment: %s\n", host.ChefEnvironment)
+			fmt.Fprintf(w, "  run list:    %s\n", strings.Join(host.RunList, ","))
+		}
+		if len(host.Attributes) > 0 {
+			attributes, _ := json.Marshal(host.Attributes)
+			fmt.Fprintf(w, "  attributes:  %s\n", attributes)
+		}
+		fmt.Fprintln(w, "  commands:")
+		for _, command := range host.Commands {
+			fmt.Fprintf(w, "    %s\n", command)
+		}
+		for _, e := range host.Errors {
+			fmt.Fprintf(w, "  error: %s\n", e)
+		}
+	}
+	_, err := fmt.Fprintf(w, "Plan: %d to create, %d with errors\n", len(plan.Hosts)-plan.Failed, plan.Failed)
+	return err
+}
+
+func (o *NodeUP) planCommands(hostname string, family *Family, target *nodeup.Target) []string {
+	commands := append(o.runCommands(hostname, family), o.hookCommands(o.PreHooks, target)...)
+	commands = append(commands, o.Bootstrap.Commands(target)...)
+	return append(commands, o.hookCommands(o.PostHooks, target)...)
+}
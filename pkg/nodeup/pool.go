@@ -0,0 +1,48 @@
+package nodeup
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// phase starts a pool of o.Concurrency workers running fn for every host
+// received from in. Hosts for which fn returns true are sent to the returned
+// channel, which is closed once in is drained and all workers are finished.
+func (o *NodeUP) phase(name string, in <-chan *Host, fn func(host *Host) bool) <-chan *Host {
+	workers := o.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	out := make(chan *Host, cap(in))
+	var active int32
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for host := range in {
+				running := atomic.AddInt32(&active, 1)
+				o.Log().Infof("Phase %s: starting host %s (queue %d, in progress %d/%d)", name, host.Hostname, len(in), running, workers)
+				ok := fn(host)
+				atomic.AddInt32(&active, -1)
+				if !ok {
+					host.Failed = name
+					o.Log().Errorf("Phase %s: host %s failed", name, host.Hostname)
+					continue
+				}
+				o.Log().Debugf("Phase %s: host %s done", name, host.Hostname)
+				out <- host
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		o.Log().Debugf("Phase %s finished", name)
+		close(out)
+	}()
+
+	return out
+}
@@ -0,0 +1,135 @@
+package nodeup
+
+import (
+	"fmt"
+	"github.com/foxdalas/nodeup/pkg/ssh"
+	"io"
+	"time"
+)
+
+const (
+	probeBackoff    = 2 * time.Second
+	probeMaxBackoff = 30 * time.Second
+
+	// boot-finished is written by cloud-init versions without status --wait
+	cloudInitWait = "cloud-init status --wait >/dev/null 2>&1; test -f /var/lib/cloud/instance/boot-finished"
+)
+
+// probeHost runs the readiness stages in order: TCP connect to port 22, SSH
+// handshake, cloud-init completion and -readyCommand. Every stage is retried
+// with backoff until its timeout, a zero timeout skips the stage. With full
+// false only the TCP stage is run.
+func (o *NodeUP) probeHost(host *Host, address string, full bool) error {
+	o.Log().Infof("Waiting host %s to be ready", address)
+
+	jumps := o.Jumps(host.Group.ChefEnvironment)
+	_, err := o.probe("tcp", address, o.ReadyTCPTimeout, func() (io.Closer, error) {
+		return nil, ssh.CheckPort(o, address, o.SSHAuth, jumps)
+	})
+	if err != nil || !full {
+		return err
+	}
+
+	conn, err := o.probe("ssh", address, o.ReadySSHTimeout, func() (io.Closer, error) {
+		// cloud-init prints the host key fingerprints late in the boot
+		if o.HostKeyCheck == "console" && len(host.Fingerprints) == 0 {
+			console, err := o.Openstack.ConsoleOutput(host.Server.ID, 0)
+			if err != nil {
+				return nil, err
+			}
+			host.Fingerprints, err = ssh.ConsoleFingerprints(console)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		client, err := ssh.New(o, address, o.SSHUser, o.SSHAuth, o.hostKeyCallback(host), jumps)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		return client, nil
+	})
+	if err != nil {
+		return err
+	}
+	if conn == nil {
+		// SSH stage is disabled, later stages need a connection
+		return nil
+	}
+	client := conn.(*ssh.Ssh)
+	// closing the connection also ends a command of an abandoned stage
+	defer client.Close()
+
+	_, err = o.probe("cloud-init", address, o.ReadyCloudInitTimeout, func() (io.Closer, error) {
+		_, err := client.Output(cloudInitWait)
+		return nil, err
+	})
+	if err != nil {
+		return err
+	}
+
+	if o.ReadyCommand == "" {
+		return nil
+	}
+	_, err = o.probe("command", address, o.ReadyCommandTimeout, func() (io.Closer, error) {
+		_, err := client.Output(o.ReadyCommand)
+		return nil, err
+	})
+	return err
+}
+
+// probeResult is a finished probe attempt
+type probeResult struct {
+	conn io.Closer
+	err  error
+}
+
+// probe retries fn with exponential backoff until it succeeds or timeout
+// passes and returns the connection fn opened, if any. A blocking fn is
+// abandoned at the timeout, a connection it opens later is closed.
+func (o *NodeUP) probe(stage string, address string, timeout time.Duration, fn func() (io.Closer, error)) (io.Closer, error) {
+	if timeout <= 0 {
+		return nil, nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	backoff := probeBackoff
+	for attempt := 1; ; attempt++ {
+		done := make(chan probeResult, 1)
+		go func() {
+			conn, err := fn()
+			done <- probeResult{conn, err}
+		}()
+
+		var result probeResult
+		select {
+		case result = <-done:
+		case <-time.After(time.Until(deadline)):
+			go closeLate(done)
+			return nil, fmt.Errorf("%s probe timed out after %s", stage, timeout)
+		}
+		err := result.err
+		if err == nil {
+			o.Log().Debugf("Host %s passed %s probe", address, stage)
+			return result.conn, nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return nil, fmt.Errorf("%s probe failed after %s: %s", stage, timeout, err)
+		}
+		o.Log().Warnf("Host %s %s probe #%d: %s", address, stage, attempt, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > probeMaxBackoff {
+			backoff = probeMaxBackoff
+		}
+	}
+}
+
+// closeLate closes the connection of an abandoned probe attempt
+func closeLate(done chan probeResult) {
+	result := <-done
+	if result.conn != nil {
+		result.conn.Close()
+	}
+}
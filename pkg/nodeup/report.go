@@ -0,0 +1,62 @@
+package nodeup
+
+import (
+	"github.com/foxdalas/nodeup/pkg/nodeup_const"
+	"os"
+)
+
+// report logs the result of every host with the provider run summary parsed
+// from the host log and returns the number of failed hosts
+func (o *NodeUP) report(hosts []*Host) int {
+	failed := 0
+	o.Log().Info("Summary:")
+	for _, host := range hosts {
+		host.Summary = o.summarize(host)
+		// with -ignoreFail failed hosts pass all phases
+		if host.Failed == "" && (host.Err != nil || host.Summary != nil && !host.Summary.Finished) {
+			host.Failed = "bootstrap"
+		}
+
+		if host.Failed == "" {
+			if host.Summary != nil {
+				o.Log().Infof("  %s: ok, %d/%d resources updated in %s", host.Hostname, host.Summary.Updated, host.Summary.Total, host.Summary.Elapsed)
+			} else {
+				o.Log().Infof("  %s: ok", host.Hostname)
+			}
+			continue
+		}
+
+		failed++
+		o.Log().Errorf("  %s: failed in %s phase", host.Hostname, host.Failed)
+		if host.Err != nil {
+			o.Log().Errorf("    error: %s", host.Err)
+		}
+		if host.Summary != nil {
+			if host.Summary.Resource != "" {
+				o.Log().Errorf("    resource: %s", host.Summary.Resource)
+			}
+			if host.Summary.Exception != "" {
+				o.Log().Errorf("    exception: %s: %s", host.Summary.Exception, host.Summary.Message)
+			}
+			if host.Summary.Elapsed != "" {
+				o.Log().Errorf("    %d resources updated in %s", host.Summary.Updated, host.Summary.Elapsed)
+			}
+		}
+	}
+	return failed
+}
+
+func (o *NodeUP) summarize(host *Host) *nodeup.Summary {
+	summarizer, ok := o.Bootstrap.(nodeup.Summarizer)
+	if !ok || host.LogFile == nil {
+		return nil
+	}
+
+	f, err := os.Open(host.LogFile.Name())
+	if err != nil {
+		o.Log().Errorf("Can't read log file %s: %s", host.LogFile.Name(), err)
+		return nil
+	}
+	defer f.Close()
+	return summarizer.Summarize(f)
+}
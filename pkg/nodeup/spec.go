@@ -0,0 +1,197 @@
+package nodeup
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"strings"
+)
+
+// LoadGroups fills o.Groups from the -spec file or, without one, from the
+// single-group command line flags. Unset group fields fall back to the flags.
+func (o *NodeUP) LoadGroups() error {
+	defaults, err := o.defaultGroup()
+	if err != nil {
+		return err
+	}
+
+	if o.SpecPath == "" {
+		o.Groups = []*Group{defaults}
+	} else {
+		o.Groups, err = o.readSpec(defaults)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = o.applyAttributes()
+	if err != nil {
+		return err
+	}
+	return o.validateGroups()
+}
+
+func (o *NodeUP) readSpec(defaults *Group) ([]*Group, error) {
+	data, err := ioutil.ReadFile(o.SpecPath)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &Spec{}
+	err = yaml.UnmarshalStrict(data, spec)
+	if err != nil {
+		return nil, fmt.Errorf("Spec %s: %s", o.SpecPath, err)
+	}
+	if len(spec.Groups) == 0 {
+		return nil, fmt.Errorf("Spec %s: no groups defined", o.SpecPath)
+	}
+
+	for _, group := range spec.Groups {
+		if group.Count == 0 {
+			group.Count = 1
+		}
+		if group.Flavor == "" {
+			group.Flavor = defaults.Flavor
+		}
+		if group.Image == "" && len(group.ImageProperties) == 0 {
+			group.Image = defaults.Image
+			group.ImageProperties = defaults.ImageProperties
+		}
+		if len(group.Networks) == 0 {
+			group.Networks = defaults.Networks
+		}
+		if group.AvailabilityZone == "" {
+			group.AvailabilityZone = defaults.AvailabilityZone
+		}
+		if group.ServerGroup == "" {
+			group.ServerGroup = defaults.ServerGroup
+		}
+		if group.ChefEnvironment == "" {
+			group.ChefEnvironment = defaults.ChefEnvironment
+		}
+		if len(group.RunList) == 0 {
+			group.RunList = defaults.RunList
+		}
+		if group.PolicyName == "" {
+			group.PolicyName = defaults.PolicyName
+		}
+		if group.PolicyGroup == "" {
+			group.PolicyGroup = defaults.PolicyGroup
+		}
+	}
+	return spec.Groups, nil
+}
+
+// applyAttributes merges node attributes for every group. The -attributes
+// file is overridden by group attributes, which are overridden by -attr.
+func (o *NodeUP) applyAttributes() error {
+	var err error
+	global := make(map[string]interface{})
+	if o.ChefAttributesPath != "" {
+		global, err = loadAttributes(o.ChefAttributesPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, group := range o.Groups {
+		attributes := mergeAttributes(nil, global)
+		attributes = mergeAttributes(attributes, stringValue(group.Attributes).(map[string]interface{}))
+		for _, attr := range o.ChefAttrs {
+			err = setAttribute(attributes, attr)
+			if err != nil {
+				return err
+			}
+		}
+		group.Attributes = attributes
+	}
+	return nil
+}
+
+func (o *NodeUP) defaultGroup() (*Group, error) {
+	properties, err := parseProperties(o.OSImageProperties)
+	if err != nil {
+		return nil, err
+	}
+
+	group := &Group{
+		Name:             o.Name,
+		Count:            o.Count,
+		Flavor:           o.OSFlavorName,
+		Image:            o.OSImage,
+		ImageProperties:  properties,
+		AvailabilityZone: o.AvailabilityZone,
+		ServerGroup:      o.OSGroupID,
+		ChefEnvironment:  o.ChefEnvironment,
+		PolicyName:       o.ChefPolicyName,
+		PolicyGroup:      o.ChefPolicyGroup,
+	}
+	if o.DefineNetworks != "" {
+		group.Networks = strings.Split(o.DefineNetworks, ",")
+	}
+	if o.ChefRunList != "" {
+		group.RunList = strings.Split(o.DeleteWhitespaces(o.ChefRunList), ",")
+	} else if o.ChefRole != "" {
+		group.RunList = []string{"role[" + o.ChefRole + "]"}
+	}
+	return group, nil
+}
+
+func (o *NodeUP) validateGroups() error {
+	for i, group := range o.Groups {
+		if group.Name == "" {
+			return fmt.Errorf("Group #%d: please provide name", i+1)
+		}
+		if group.Count < 1 {
+			return fmt.Errorf("Group %s: please provide count", group.Name)
+		}
+		if group.Count > 1 && !o.isWildcard(group.Name) {
+			return fmt.Errorf("Group %s: can't create more one host with not unique name. Please set count 1", group.Name)
+		}
+		if group.Flavor == "" {
+			return fmt.Errorf("Group %s: please provide flavor", group.Name)
+		}
+		for _, check := range group.Checks {
+			_, err := check.command()
+			if err != nil {
+				return fmt.Errorf("Group %s: %s", group.Name, err)
+			}
+		}
+		if (group.PolicyName == "") != (group.PolicyGroup == "") {
+			return fmt.Errorf("Group %s: please provide both policy name and policy group", group.Name)
+		}
+		if group.PolicyName != "" && !o.ChefEnabled() {
+			return fmt.Errorf("Group %s: policyfiles are supported only with chef bootstrap", group.Name)
+		}
+		if group.PolicyName != "" {
+			continue
+		}
+		if group.ChefEnvironment == "" && o.ChefEnabled() {
+			return fmt.Errorf("Group %s: please provide chef environment", group.Name)
+		}
+		if len(group.RunList) == 0 && (o.ChefEnabled() || o.BootstrapProvider == "chef-solo") {
+			return fmt.Errorf("Group %s: please provide chef run list", group.Name)
+		}
+	}
+	if len(o.Groups) == 0 {
+		return errors.New("Nothing to bootstrap")
+	}
+	return nil
+}
+
+// parseProperties parses key=value pairs separated by commas
+func parseProperties(s string) (map[string]string, error) {
+	properties := make(map[string]string)
+	if s == "" {
+		return properties, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("Invalid property %s, expected key=value", pair)
+		}
+		properties[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return properties, nil
+}
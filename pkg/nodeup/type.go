@@ -2,9 +2,14 @@ package nodeup
 
 import (
 	"github.com/foxdalas/nodeup/pkg/chef"
+	"github.com/foxdalas/nodeup/pkg/nodeup_const"
 	"github.com/foxdalas/nodeup/pkg/openstack"
+	"github.com/foxdalas/nodeup/pkg/ssh"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 	log "github.com/sirupsen/logrus"
+	"os"
 	"sync"
+	"time"
 )
 
 type NodeUP struct {
@@ -13,6 +18,14 @@ type NodeUP struct {
 
 	Openstack *openstack.Openstack
 	Chef      *chef.ChefClient
+	Bootstrap nodeup.Bootstrap
+
+	BootstrapProvider string
+	ScriptDir         string
+	ScriptEntrypoint  string
+	AnsiblePlaybook   string
+	AnsibleArgs       string
+	ChefArchive       string
 
 	Name              string
 	Domain            string
@@ -26,36 +39,81 @@ type NodeUP struct {
 	UsePrivateNetwork bool
 	Gateway           string
 	AvailabilityZone  string
+	SpecPath          string
+	FileSpecs         []string
+	Files             []*File
+	PreHookSpecs      []string
+	PostHookSpecs     []string
+	PreHooks          []*Hook
+	PostHooks         []*Hook
+	ChecksPath        string
+	Checks            *Checks
+	CloudInit         bool
+	CloudInitTimeout  time.Duration
+	Groups            []*Group
+	PlanMode          bool
+	PlanFormat        string
+
+	OSAuthURL         string
+	OSTenantName      string
+	OSPassword        string
+	OSUsername        string
+	OSPublicKey       string
+	OSPublicKeyPath   string
+	OSFlavorName      string
+	OSKeyName         string
+	OSGroupID         string
+	OSProjectID       string
+	OSRegionName      string
+	OSImage           string
+	OSImageProperties string
+
+	ReadyTCPTimeout       time.Duration
+	ReadySSHTimeout       time.Duration
+	ReadyCloudInitTimeout time.Duration
+	ReadyCommand          string
+	ReadyCommandTimeout   time.Duration
 
-	OSAuthURL       string
-	OSTenantName    string
-	OSPassword      string
-	OSUsername      string
-	OSPublicKey     string
-	OSPublicKeyPath string
-	OSFlavorName    string
-	OSKeyName       string
-	OSGroupID       string
-	OSProjectID     string
-	OSRegionName    string
-
-	SSHWaitRetry int
-
-	ChefVersion        string
-	ChefServerUrl      string
-	ChefClientName     string
-	ChefKeyPath        string
-	ChefKeyPem         []byte
-	ChefValidationPath string
-	ChefValidationPem  []byte
-	ChefEnvironment    string
-	ChefRole           string
+	ChefVersion         string
+	ChefPackageSource   string
+	ChefPackageChecksum string
+	ChefServerUrl       string
+	ChefClientName      string
+	ChefKeyPath         string
+	ChefKeyPem          []byte
+	ChefValidationPath  string
+	ChefValidationPem   []byte
+	ChefValidatorless   bool
+	ChefEnvironment     string
+	ChefRole            string
+	ChefRunList         string
+	ChefAttributesPath  string
+	ChefAttrs           []string
+	ChefPolicyName      string
+	ChefPolicyGroup     string
 
 	JenkinsMode   bool
 	JenkinsLogURL string
 
-	SSHUser      string
-	SSHUploadDir string
+	Creator  string
+	BuildURL string
+
+	SSHUser        string
+	SSHKey         string
+	SSHCert        string
+	SSHAuthOrder   string
+	SSHAuth        *ssh.Auth
+	SSHUploadDir   string
+	HostKeyCheck   string
+	KnownHostsPath string
+	KnownHosts     *ssh.KnownHosts
+	UserKnownHosts string
+	JumpHost       string
+	JumpHostsPath  string
+	JumpHosts      map[string][]*JumpHost
+
+	CommandTimeout   time.Duration
+	BootstrapTimeout time.Duration
 
 	DeleteNodes string
 
@@ -75,6 +133,136 @@ type NodeUP struct {
 	WaitGroup sync.WaitGroup
 }
 
+// Family is a set of bootstrap command templates for a distro family
+type Family struct {
+	Name       string
+	Hostname   string
+	Update     string
+	Gateway    []string
+	Interfaces bool
+}
+
+// CommandData is passed to command templates
+type CommandData struct {
+	Hostname  string
+	Gateway   string
+	UploadDir string
+}
+
+type Hosts struct {
+	Hostname string
+	Domain   string
+}
+
 type Interfaces struct {
 	Gateway string
 }
+
+// File is an extra file installed on every host before the bootstrap
+// provider runs. Data may be a secret and must not be logged.
+type File struct {
+	Source string
+	Dest   string
+	Owner  string
+	Mode   string
+	Data   []byte
+}
+
+// Hook is a local executable or a remote script run around the bootstrap
+type Hook struct {
+	Path   string
+	Remote bool
+
+	data   []byte
+	upload string
+}
+
+// Checks is the -checks file: smoke tests by role name, "*" for all hosts
+type Checks struct {
+	Roles map[string][]*Check `yaml:"roles"`
+}
+
+// Check is a smoke test run on the host after the bootstrap. Exactly one
+// field is set.
+type Check struct {
+	Port int    `yaml:"port"`
+	Unit string `yaml:"unit"`
+	HTTP string `yaml:"http"`
+	File string `yaml:"file"`
+}
+
+// JumpHost is a bastion in the -jumpHosts file. Without key the SSH agent
+// is used.
+type JumpHost struct {
+	Host string `yaml:"host"`
+	User string `yaml:"user"`
+	Key  string `yaml:"key"`
+
+	auth *ssh.Auth
+}
+
+// Spec is a fleet spec file passed with -spec
+type Spec struct {
+	Groups []*Group `yaml:"groups"`
+}
+
+// Group describes hosts sharing the same settings
+type Group struct {
+	Name             string                 `yaml:"name"`
+	Count            int                    `yaml:"count"`
+	Flavor           string                 `yaml:"flavor"`
+	Image            string                 `yaml:"image"`
+	ImageProperties  map[string]string      `yaml:"image_properties"`
+	Networks         []string               `yaml:"networks"`
+	AvailabilityZone string                 `yaml:"availability_zone"`
+	ServerGroup      string                 `yaml:"server_group"`
+	ChefEnvironment  string                 `yaml:"chef_environment"`
+	RunList          []string               `yaml:"run_list"`
+	PolicyName       string                 `yaml:"policy_name"`
+	PolicyGroup      string                 `yaml:"policy_group"`
+	Attributes       map[string]interface{} `yaml:"attributes"`
+	Checks           []*Check               `yaml:"checks"`
+
+	resolved *openstack.Resolved
+}
+
+// Plan is the result of -plan
+type Plan struct {
+	Hosts  []*PlanHost `json:"hosts"`
+	Failed int         `json:"failed"`
+}
+
+type PlanHost struct {
+	Hostname         string                 `json:"hostname"`
+	Group            string                 `json:"group"`
+	Flavor           string                 `json:"flavor"`
+	Networks         []string               `json:"networks"`
+	AvailabilityZone string                 `json:"availability_zone,omitempty"`
+	ServerGroup      string                 `json:"server_group,omitempty"`
+	Resolved         *openstack.Resolved    `json:"resolved,omitempty"`
+	ChefEnvironment  string                 `json:"chef_environment"`
+	RunList          []string               `json:"run_list"`
+	PolicyName       string                 `json:"policy_name,omitempty"`
+	PolicyGroup      string                 `json:"policy_group,omitempty"`
+	Attributes       map[string]interface{} `json:"attributes,omitempty"`
+	Commands         []string               `json:"commands"`
+	Errors           []string               `json:"errors,omitempty"`
+}
+
+// Host is passed between the create, ssh and bootstrap phases
+type Host struct {
+	Hostname  string
+	Group     *Group
+	Server    *servers.Server
+	Addresses []string
+	Family    *Family
+	LogFile   *os.File
+
+	// Fingerprints are the host key fingerprints from the console log
+	Fingerprints []string
+
+	// Failed is the name of the phase the host failed in
+	Failed  string
+	Err     error
+	Summary *nodeup.Summary
+}
@@ -2,6 +2,7 @@ package nodeup
 
 import (
 	"github.com/sirupsen/logrus"
+	"io"
 )
 
 type NodeUP interface {
@@ -19,3 +20,28 @@ type Ssh interface {
 type ChefClient interface {
 	ListNodes()
 }
+
+// Bootstrap is implemented by bootstrap providers. nodeup creates the server,
+// waits for SSH and sets the hostname, then hands the host over to the provider.
+type Bootstrap interface {
+	// Prepare returns files to upload into the SSH upload directory
+	Prepare(target *Target) (map[string][]byte, error)
+	// Commands returns commands to run on the host after the upload
+	Commands(target *Target) []string
+	// Verify is called after all commands succeeded
+	Verify(target *Target) error
+	// Cleanup removes everything the provider registered for a failed host
+	Cleanup(target *Target) error
+}
+
+// Runner is implemented by bootstrap providers configuring the host from the
+// build machine. Run is called after the provider commands.
+type Runner interface {
+	Run(target *Target) error
+}
+
+// Summarizer is implemented by bootstrap providers able to summarize their
+// run from the host log
+type Summarizer interface {
+	Summarize(log io.Reader) *Summary
+}
@@ -0,0 +1,36 @@
+package nodeup
+
+import (
+	"io"
+)
+
+// Target is a host handed over to a bootstrap provider
+type Target struct {
+	Hostname        string
+	Domain          string
+	ServerID        string
+	Address         string
+	Addresses       []string
+	ServerAddresses []string
+	Family          string
+	UploadDir       string
+	Environment     string
+	RunList         []string
+	PolicyName      string
+	PolicyGroup     string
+	Attributes      map[string]interface{}
+	Tags            []string
+	Metadata        map[string]string
+	Log             io.Writer
+}
+
+// Summary is the result of the last provider run found in the host log
+type Summary struct {
+	Finished  bool
+	Updated   int
+	Total     int
+	Elapsed   string
+	Resource  string
+	Exception string
+	Message   string
+}
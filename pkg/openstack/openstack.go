@@ -3,6 +3,7 @@ package openstack
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/foxdalas/nodeup/pkg/nodeup_const"
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
@@ -50,20 +51,87 @@ func New(nodeup nodeup.NodeUP, key string, keyName string, flavor string) *Opens
 	return o
 }
 
-func (o *Openstack) getFlavorByName() string {
-	o.Log().Debugf("Searching FlavorID for Flavor name: %s", o.flavorName)
-	flavorID, err := flavors.IDFromName(o.client, o.flavorName)
-	o.assertError(err, "Flavor")
+func (o *Openstack) getFlavorByName(flavorName string) (string, error) {
+	if flavorName == "" {
+		flavorName = o.flavorName
+	}
+	o.Log().Debugf("Searching FlavorID for Flavor name: %s", flavorName)
+	flavorID, err := flavors.IDFromName(o.client, flavorName)
+	if err != nil {
+		return "", err
+	}
 
 	o.Log().Debugf("Found flavor id: %s", flavorID)
-	return flavorID
+	return flavorID, nil
 }
 
-func (o *Openstack) getImageByName() string {
-	imageID, err := images.IDFromName(o.client, "Ubuntu 16.04-server (64 bit)")
-	o.assertError(err, "Error image")
+// getImage finds an image by ID or name. Without an image it picks the newest
+// active image whose metadata matches all properties, e.g. os_distro=ubuntu.
+func (o *Openstack) getImage(image string, properties map[string]string) (*images.Image, error) {
+	if image == "" && len(properties) == 0 {
+		image = defaultImage
+	}
+
+	if image != "" {
+		o.Log().Debugf("Searching image %s", image)
+		found, err := images.Get(o.client, image).Extract()
+		if err == nil {
+			return found, nil
+		}
+		imageID, err := images.IDFromName(o.client, image)
+		if err != nil {
+			return nil, err
+		}
+		return images.Get(o.client, imageID).Extract()
+	}
 
-	return imageID
+	o.Log().Debugf("Searching newest image with properties %v", properties)
+	allPages, err := images.ListDetail(o.client, images.ListOpts{Status: "ACTIVE"}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	allImages, err := images.ExtractImages(allPages)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []images.Image
+	for _, i := range allImages {
+		if matchMetadata(i.Metadata, properties) {
+			matched = append(matched, i)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("No image found with properties %v", properties)
+	}
+	sort.Sort(sortedImagesByCreated(matched))
+
+	return &matched[0], nil
+}
+
+// Resolve looks up the flavor, image and network IDs a server would be created with
+func (o *Openstack) Resolve(flavor string, image string, imageProperties map[string]string, networks string) (*Resolved, error) {
+	flavorID, err := o.getFlavorByName(flavor)
+	if err != nil {
+		return nil, fmt.Errorf("Flavor %s: %s", flavor, err)
+	}
+	foundImage, err := o.getImage(image, imageProperties)
+	if err != nil {
+		return nil, fmt.Errorf("Image: %s", err)
+	}
+	networksIDs, err := o.getNetworkIDs(networks)
+	if err != nil {
+		return nil, fmt.Errorf("Networks %s: %s", networks, err)
+	}
+	distro := imageDistro(foundImage)
+	o.Log().Debugf("Using image %s (%s) with distro %s", foundImage.Name, foundImage.ID, distro)
+	return &Resolved{
+		FlavorID:   flavorID,
+		ImageID:    foundImage.ID,
+		ImageName:  foundImage.Name,
+		Distro:     distro,
+		NetworkIDs: networksIDs,
+	}, nil
 }
 
 func (o *Openstack) getNetworkIDs(defineNetworks string) ([]string, error) {
@@ -147,27 +215,22 @@ func (o *Openstack) createAdminKey() bool {
 	return true
 }
 
-func (o *Openstack) CreateSever(hostname string, group string, networks string, availabilityZone string) (*servers.Server, error) {
+// CreateSever creates the server and waits until it is active. userData is
+// passed to cloud-init if not nil.
+func (o *Openstack) CreateSever(hostname string, resolved *Resolved, group string, availabilityZone string, userData []byte) (*servers.Server, error) {
+	var err error
 
-	if o.isServerExist(hostname) {
+	if o.IsServerExist(hostname) {
 		o.Log().Fatalf("Server %s already exists", hostname)
 	}
 
-	flavorID := o.getFlavorByName()
-	imageID := o.getImageByName()
-	networksIDs, err := o.getNetworkIDs(networks)
-	if err != nil {
-		o.Log().Errorf("Error networks: %s", err)
-		return nil, err
-	}
-
 	o.Log().Infof("Creating server with hostname %s", hostname)
 
 	o.createAdminKey()
 
 	var s []servers.Network
 
-	for _, n := range networksIDs {
+	for _, n := range resolved.NetworkIDs {
 		s = append(s, servers.Network{UUID: n})
 	}
 
@@ -175,10 +238,11 @@ func (o *Openstack) CreateSever(hostname string, group string, networks string,
 
 	serverCreateOpts := servers.CreateOpts{
 		Name:        hostname,
-		FlavorRef:   flavorID,
-		ImageRef:    imageID,
+		FlavorRef:   resolved.FlavorID,
+		ImageRef:    resolved.ImageID,
 		Networks:    s,
 		ConfigDrive: &configDrive,
+		UserData:    userData,
 	}
 
 	// TODO: add auto balancer
@@ -390,7 +454,7 @@ func (o *Openstack) GetHypervisorWithSensitiveCriteria(criteria string) hypervis
 	return h
 }
 
-func (o *Openstack) isServerExist(name string) bool {
+func (o *Openstack) IsServerExist(name string) bool {
 	_, err := servers.IDFromName(o.client, name)
 	if err != nil {
 		o.Log().Debug(err)
@@ -401,6 +465,12 @@ func (o *Openstack) isServerExist(name string) bool {
 	}
 }
 
+// ConsoleOutput returns the last lines of the server console log, all of it
+// if lines is 0
+func (o *Openstack) ConsoleOutput(sid string, lines int) (string, error) {
+	return servers.ShowConsoleOutput(o.client, sid, servers.ShowConsoleOutputOpts{Length: lines}).Extract()
+}
+
 func (o *Openstack) DeleteServer(sid string) error {
 	o.Log().Infof("Deleting server with ID %s", sid)
 	result := servers.Delete(o.client, sid)
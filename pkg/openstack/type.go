@@ -7,9 +7,12 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/hypervisors"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/images"
 	"time"
 )
 
+const defaultImage = "Ubuntu 16.04-server (64 bit)"
+
 type Openstack struct {
 	nodeup     nodeup.NodeUP
 	client     *gophercloud.ServiceClient
@@ -96,6 +99,15 @@ type Server struct {
 	HypervisorHostname string `json:"OS-EXT-SRV-ATTR:hypervisor_hostname"`
 }
 
+// Resolved holds the OpenStack IDs a server is created with
+type Resolved struct {
+	FlavorID   string   `json:"flavor_id"`
+	ImageID    string   `json:"image_id"`
+	ImageName  string   `json:"image_name"`
+	Distro     string   `json:"distro"`
+	NetworkIDs []string `json:"network_ids"`
+}
+
 type Fault struct {
 	Code    int       `json:"code"`
 	Created time.Time `json:"created"`
@@ -103,5 +115,6 @@ type Fault struct {
 	Message string    `json:"message"`
 }
 
+type sortedImagesByCreated []images.Image
 type sortedHypervisorsByvCPU []hypervisors.Hypervisor
 type sortedHypervisorsBMemory []hypervisors.Hypervisor
@@ -1,6 +1,11 @@
 package openstack
 
-import "github.com/sirupsen/logrus"
+import (
+	"fmt"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/images"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
 
 func (o *Openstack) Log() *logrus.Entry {
 	log := o.nodeup.Log().WithField("context", "openstack")
@@ -13,6 +18,34 @@ func (o *Openstack) assertError(err error, message string) {
 	}
 }
 
+func matchMetadata(metadata map[string]interface{}, properties map[string]string) bool {
+	for key, value := range properties {
+		v, ok := metadata[key]
+		if !ok || fmt.Sprint(v) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// imageDistro returns the os_distro image property or guesses it from the image name
+func imageDistro(image *images.Image) string {
+	if distro, ok := image.Metadata["os_distro"]; ok {
+		return strings.ToLower(fmt.Sprint(distro))
+	}
+	name := strings.ToLower(image.Name)
+	for _, distro := range []string{"ubuntu", "debian", "centos", "rhel", "rocky", "almalinux", "fedora", "opensuse", "sles"} {
+		if strings.Contains(name, distro) {
+			return distro
+		}
+	}
+	return ""
+}
+
+func (c sortedImagesByCreated) Len() int           { return len(c) }
+func (c sortedImagesByCreated) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c sortedImagesByCreated) Less(i, j int) bool { return c[i].Created > c[j].Created }
+
 func (c sortedHypervisorsByvCPU) Len() int           { return len(c) }
 func (c sortedHypervisorsByvCPU) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
 func (c sortedHypervisorsByvCPU) Less(i, j int) bool { return c[i].VCPUsUsed > c[j].VCPUsUsed }
@@ -240,7 +240,7 @@ func (e *Echo) serverChefRun(c echo.Context) error {
 	ipAddresses := e.nodeup.GetAddress(server.Addresses)
 	e.Logger.Info(ipAddresses)
 	for _, ipAddress := range ipAddresses {
-		sshClient, err := ssh.New(e.nodeup, ipAddress, e.nodeup.WebSSHUser)
+		sshClient, err := ssh.New(e.nodeup, ipAddress, e.nodeup.WebSSHUser, e.nodeup.SSHAuth, e.nodeup.HostKeyCallback(), e.nodeup.Jumps(""))
 		if err != nil {
 			e.Logger.Error(err)
 			continue
@@ -248,8 +248,11 @@ func (e *Echo) serverChefRun(c echo.Context) error {
 			// Use --force-formatter for stdout via ssh. https://github.com/chef/chef-provisioning/issues/274
 			go func(command string) {
 				e.saveState(id, "chef", 99)
-				err := sshClient.RunCommandPipe(command, logFile)
+				executor := ssh.NewExecutor(sshClient, e.nodeup.CommandTimeout, 0)
+				defer executor.Close()
+				result, err := executor.Run(command, logFile)
 				if err != nil {
+					e.Logger.Errorf("Chef run on %s failed after %s: %s", id, result.Duration, err)
 					e.saveState(id, "chef", 1)
 				} else {
 					e.saveState(id, "chef", 0)
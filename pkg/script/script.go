@@ -0,0 +1,132 @@
+package script
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/foxdalas/nodeup/pkg/nodeup_const"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var _ nodeup.Bootstrap = &Script{}
+
+// New returns a bootstrap provider uploading the local directory dir to the
+// host and running entrypoint from it
+func New(nodeup nodeup.NodeUP, dir string, entrypoint string) (*Script, error) {
+	info, err := os.Stat(filepath.Join(dir, entrypoint))
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&0111 == 0 {
+		return nil, fmt.Errorf("Script entrypoint %s is not executable", entrypoint)
+	}
+
+	archive, err := createArchive(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Script{
+		nodeup:     nodeup,
+		dir:        dir,
+		entrypoint: entrypoint,
+		archive:    archive,
+	}
+	s.Log().Debugf("Script directory %s packed into %d bytes", dir, len(archive))
+
+	return s, nil
+}
+
+func (s *Script) Prepare(target *nodeup.Target) (map[string][]byte, error) {
+	data := make(map[string][]byte)
+	data[archiveName] = s.archive
+	return data, nil
+}
+
+func (s *Script) Commands(target *nodeup.Target) []string {
+	dir := target.UploadDir + "/" + scriptDir
+	archive := target.UploadDir + "/" + archiveName
+
+	data := []string{
+		"rm -rf " + dir + " && mkdir " + dir + " && tar -xzf " + archive + " -C " + dir + " && rm " + archive,
+		fmt.Sprintf("cd %s && sudo env %s %s %s %s",
+			quote(dir), quote("NODEUP_HOSTNAME="+target.Hostname), quote("NODEUP_DOMAIN="+target.Domain),
+			quote("NODEUP_ENVIRONMENT="+target.Environment), quote("./"+s.entrypoint)),
+		"rm -rf " + dir,
+	}
+	return data
+}
+
+func (s *Script) Verify(target *nodeup.Target) error {
+	return nil
+}
+
+func (s *Script) Cleanup(target *nodeup.Target) error {
+	return nil
+}
+
+func createArchive(dir string) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil || name == "." {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		err = tw.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return nil, err
+	}
+	err = gz.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// quote quotes s for the remote shell
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,20 @@
+package script
+
+import (
+	"github.com/foxdalas/nodeup/pkg/nodeup_const"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	archiveName = "nodeup-script.tar.gz"
+	scriptDir   = "nodeup-script"
+)
+
+type Script struct {
+	nodeup     nodeup.NodeUP
+	dir        string
+	entrypoint string
+	archive    []byte
+
+	log *logrus.Entry
+}
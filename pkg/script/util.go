@@ -0,0 +1,8 @@
+package script
+
+import "github.com/sirupsen/logrus"
+
+func (s *Script) Log() *logrus.Entry {
+	log := s.nodeup.Log().WithField("context", "script")
+	return log
+}
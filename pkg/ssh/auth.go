@@ -0,0 +1,156 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"io/ioutil"
+	"net"
+	"os"
+)
+
+// SSH authentication methods for -sshAuth
+const (
+	AuthAgent    = "agent"
+	AuthKey      = "key"
+	AuthCert     = "cert"
+	AuthPassword = "password"
+)
+
+// Environment variables with the key passphrase and the password
+const (
+	KeyPassphraseEnv = "NODEUP_SSH_KEY_PASSPHRASE"
+	PasswordEnv      = "NODEUP_SSH_PASSWORD"
+)
+
+// NewAuth loads the SSH authentication methods tried in order. Methods which
+// aren't configured, like the agent without SSH_AUTH_SOCK, are skipped. The
+// certificate defaults to KEY-cert.pub next to the key.
+func NewAuth(order []string, keyPath, certPath, passphrase, password string) (*Auth, error) {
+	a := &Auth{password: password}
+
+	seen := make(map[string]bool)
+	for _, name := range order {
+		switch name {
+		case AuthAgent, AuthKey, AuthCert, AuthPassword:
+		default:
+			return nil, fmt.Errorf("Unknown SSH authentication method %s", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("SSH authentication method %s is repeated", name)
+		}
+		seen[name] = true
+		a.order = append(a.order, name)
+	}
+
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" && seen[AuthAgent] {
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, fmt.Errorf("SSH agent %s: %s", socket, err)
+		}
+		a.agent = agent.NewClient(conn)
+	}
+
+	if keyPath != "" {
+		data, err := ioutil.ReadFile(keyPath)
+		if err != nil {
+			return nil, err
+		}
+		if passphrase != "" {
+			a.key, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+		} else {
+			a.key, err = ssh.ParsePrivateKey(data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("SSH key %s: %s", keyPath, err)
+		}
+
+		if certPath == "" {
+			if _, err := os.Stat(keyPath + "-cert.pub"); err == nil {
+				certPath = keyPath + "-cert.pub"
+			}
+		}
+	}
+
+	if certPath != "" {
+		if a.key == nil {
+			return nil, errors.New("SSH certificate " + certPath + " needs its key")
+		}
+		data, err := ioutil.ReadFile(certPath)
+		if err != nil {
+			return nil, err
+		}
+		pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("SSH certificate %s: %s", certPath, err)
+		}
+		cert, ok := pub.(*ssh.Certificate)
+		if !ok {
+			return nil, errors.New("SSH certificate " + certPath + " is not a certificate")
+		}
+		a.cert, err = ssh.NewCertSigner(cert, a.key)
+		if err != nil {
+			return nil, fmt.Errorf("SSH certificate %s: %s", certPath, err)
+		}
+	}
+
+	return a, nil
+}
+
+// Methods returns the methods for ssh.ClientConfig. The agent, key and
+// certificate are all public keys, the SSH client tries one public key method
+// only, so their signers are offered together in order.
+func (a *Auth) Methods() []ssh.AuthMethod {
+	var methods []ssh.AuthMethod
+	publicKeys := false
+	for _, name := range a.order {
+		switch name {
+		case AuthPassword:
+			if a.password != "" {
+				methods = append(methods, ssh.Password(a.password), ssh.KeyboardInteractive(a.challenge))
+			}
+		default:
+			if !publicKeys && (a.agent != nil || a.key != nil) {
+				methods = append(methods, ssh.PublicKeysCallback(a.signers))
+				publicKeys = true
+			}
+		}
+	}
+	return methods
+}
+
+func (a *Auth) signers() ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+	for _, name := range a.order {
+		switch name {
+		case AuthAgent:
+			if a.agent != nil {
+				agentSigners, err := a.agent.Signers()
+				if err != nil {
+					return nil, err
+				}
+				signers = append(signers, agentSigners...)
+			}
+		case AuthKey:
+			if a.key != nil {
+				signers = append(signers, a.key)
+			}
+		case AuthCert:
+			if a.cert != nil {
+				signers = append(signers, a.cert)
+			}
+		}
+	}
+	return signers, nil
+}
+
+// challenge answers keyboard-interactive prompts of rescue images with the
+// password
+func (a *Auth) challenge(user, instruction string, questions []string, echos []bool) ([]string, error) {
+	answers := make([]string, len(questions))
+	for i := range answers {
+		answers[i] = a.password
+	}
+	return answers, nil
+}
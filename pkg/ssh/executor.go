@@ -0,0 +1,185 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// NewExecutor runs commands over s. A zero commandTimeout or timeout means no
+// limit, timeout is counted from now.
+func NewExecutor(s *Ssh, commandTimeout time.Duration, timeout time.Duration) *Executor {
+	e := &Executor{
+		ssh:            s,
+		commandTimeout: commandTimeout,
+	}
+	if timeout > 0 {
+		e.deadline = time.Now().Add(timeout)
+	}
+	return e
+}
+
+// Run runs command with stdout and stderr written to out. The error is set
+// when the command fails, times out or the connection drops. A command
+// running out of time is killed and the connection is closed to release it.
+func (e *Executor) Run(command string, out io.Writer) (*Result, error) {
+	return e.run(command, out, out)
+}
+
+// Output runs command and returns its stdout
+func (e *Executor) Output(command string) ([]byte, error) {
+	var b bytes.Buffer
+	_, err := e.run(command, &b, nil)
+	return b.Bytes(), err
+}
+
+func (e *Executor) run(command string, stdout io.Writer, stderr io.Writer) (*Result, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.exec(command, stdout, stderr)
+}
+
+// exec runs command, the caller holds the lock
+func (e *Executor) exec(command string, stdout io.Writer, stderr io.Writer) (*Result, error) {
+	result := &Result{Command: command, ExitCode: -1}
+	timeout, err := e.timeout()
+	if err != nil {
+		return result, err
+	}
+
+	session, err := e.session()
+	if err != nil {
+		return result, err
+	}
+	defer session.Close()
+
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+	tail := &tailBuffer{limit: stderrLimit}
+	session.Stdout = stdout
+	session.Stderr = io.MultiWriter(stderr, tail)
+
+	e.ssh.Log().Debugf("Running %s", command)
+	started := time.Now()
+	err = session.Start(command)
+	if err != nil {
+		return result, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case err = <-done:
+	case <-expired:
+		session.Signal(ssh.SIGKILL)
+		e.ssh.Close()
+		<-done
+		err = fmt.Errorf("Timed out after %s", timeout)
+	}
+	result.Duration = time.Since(started)
+	result.Stderr = tail.String()
+
+	switch exit := err.(type) {
+	case nil:
+		result.ExitCode = 0
+	case *ssh.ExitError:
+		result.ExitCode = exit.ExitStatus()
+		err = result.error()
+	case *ssh.ExitMissingError:
+		e.ssh.Close()
+		err = errors.New("Connection lost before the command finished")
+	}
+	e.ssh.Log().Debugf("Finished %s with exit code %d in %s", command, result.ExitCode, result.Duration)
+	return result, err
+}
+
+// Close closes the connection
+func (e *Executor) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.ssh.Close()
+}
+
+// timeout returns the time left for the next command
+func (e *Executor) timeout() (time.Duration, error) {
+	timeout := e.commandTimeout
+	if e.deadline.IsZero() {
+		return timeout, nil
+	}
+
+	left := time.Until(e.deadline)
+	if left <= 0 {
+		return 0, errors.New("Deadline exceeded")
+	}
+	if timeout == 0 || left < timeout {
+		timeout = left
+	}
+	return timeout, nil
+}
+
+// session opens a session, dialing the host again if the connection is gone
+func (e *Executor) session() (*ssh.Session, error) {
+	if client := e.ssh.conn(); client != nil {
+		session, err := client.NewSession()
+		if err == nil {
+			return session, nil
+		}
+		e.ssh.Log().Warnf("SSH session to %s failed: %s", e.ssh.address, err)
+	}
+
+	err := e.ssh.reconnect()
+	if err != nil {
+		return nil, err
+	}
+	client := e.ssh.conn()
+	if client == nil {
+		return nil, errors.New("Not connected to " + e.ssh.address)
+	}
+	return client.NewSession()
+}
+
+// error describes a failed command by its exit code and last stderr line
+func (r *Result) error() error {
+	lines := strings.Split(strings.TrimSpace(r.Stderr), "\n")
+	if last := lines[len(lines)-1]; last != "" {
+		return fmt.Errorf("Exit code %d: %s", r.ExitCode, last)
+	}
+	return fmt.Errorf("Exit code %d", r.ExitCode)
+}
+
+// tailBuffer keeps the last limit bytes written
+type tailBuffer struct {
+	limit int
+	data  []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.data = append(b.data, p...)
+	if len(b.data) > b.limit {
+		b.data = b.data[len(b.data)-b.limit:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	return string(b.data)
+}
@@ -0,0 +1,116 @@
+package ssh
+
+import (
+	"errors"
+	"github.com/foxdalas/nodeup/pkg/nodeup_const"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"strings"
+	"time"
+)
+
+// CheckPort checks that the SSH port of address accepts connections, through
+// the jump hosts if any are given
+func CheckPort(nodeup nodeup.NodeUP, address string, auth *Auth, jumps []Jump) error {
+	if len(jumps) == 0 {
+		conn, err := net.DialTimeout("tcp", address+":22", dialTimeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	s := &Ssh{nodeup: nodeup, auth: auth}
+	defer s.Close()
+	conn, err := s.dialTCP(address+":22", jumps)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// dial opens an SSH connection to address through the jump hosts
+func (s *Ssh) dial(address string, config *ssh.ClientConfig, jumps []Jump) (*ssh.Client, error) {
+	var conn net.Conn
+	var err error
+	if len(jumps) == 0 {
+		conn, err = tcpDialer.Dial("tcp", address)
+	} else {
+		conn, err = s.dialTCP(address, jumps)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newClient(conn, address, config)
+}
+
+// dialTCP connects to every jump host through the previous one and opens a
+// TCP connection to address from the last one
+func (s *Ssh) dialTCP(address string, jumps []Jump) (net.Conn, error) {
+	var client *ssh.Client
+	for _, jump := range jumps {
+		config, err := s.jumpConfig(jump)
+		if err != nil {
+			return nil, err
+		}
+
+		jumpAddress := jump.Address
+		if !strings.Contains(jumpAddress, ":") {
+			jumpAddress += ":22"
+		}
+		s.Log().Debugf("Connecting to jump host %s@%s", jump.User, jumpAddress)
+
+		var conn net.Conn
+		if client == nil {
+			conn, err = tcpDialer.Dial("tcp", jumpAddress)
+		} else {
+			conn, err = client.Dial("tcp", jumpAddress)
+		}
+		if err == nil {
+			client, err = newClient(conn, jumpAddress, config)
+		}
+		if err != nil {
+			return nil, errors.New("Jump host " + jumpAddress + ": " + err.Error())
+		}
+		s.jumps = append(s.jumps, client)
+	}
+	return client.Dial("tcp", address)
+}
+
+func (s *Ssh) jumpConfig(jump Jump) (*ssh.ClientConfig, error) {
+	// jump hosts are reachable from outside, their key is always checked
+	if jump.HostKeyCallback == nil {
+		return nil, errors.New("Jump host " + jump.Address + " has no host key check")
+	}
+
+	auth := s.auth
+	if jump.Auth != nil {
+		auth = jump.Auth
+	}
+
+	return &ssh.ClientConfig{
+		User:            jump.User,
+		Auth:            auth.Methods(),
+		HostKeyCallback: jump.HostKeyCallback,
+		Timeout:         dialTimeout,
+	}, nil
+}
+
+// tcpDialer connects with TCP keepalives
+var tcpDialer = &net.Dialer{
+	Timeout:   dialTimeout,
+	KeepAlive: keepaliveInterval,
+}
+
+// newClient runs the SSH handshake on conn, a host stalling the handshake
+// fails after dialTimeout
+func newClient(conn net.Conn, address string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	c, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
@@ -0,0 +1,172 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// console lines may be prefixed with a syslog tag and a timestamp
+	consoleFingerprint = regexp.MustCompile(`\b\d+ (SHA256:[A-Za-z0-9+/=]+|MD5:[0-9a-f:]+|[0-9a-f]{2}(:[0-9a-f]{2}){15})\s`)
+	consoleKey         = regexp.MustCompile(`((ssh|ecdsa)-[a-z0-9-]+ AAAA[A-Za-z0-9+/=]+)`)
+)
+
+// NewKnownHosts opens the known_hosts store at path, creating it if needed
+func NewKnownHosts(path string) (*KnownHosts, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return &KnownHosts{path: path}, nil
+}
+
+// Strict accepts only keys already in the store or in one of the read-only
+// known_hosts files
+func (k *KnownHosts) Strict(files ...string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		k.mu.Lock()
+		defer k.mu.Unlock()
+
+		callback, err := k.callback(files)
+		if err != nil {
+			return err
+		}
+		return callback(hostname, remote, key)
+	}
+}
+
+// TrustOnFirstUse records hosts unknown to the store and the read-only
+// known_hosts files, and rejects changed keys
+func (k *KnownHosts) TrustOnFirstUse(files ...string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		k.mu.Lock()
+		defer k.mu.Unlock()
+
+		callback, err := k.callback(files)
+		if err != nil {
+			return err
+		}
+		err = callback(hostname, remote, key)
+		if keyErr, ok := err.(*knownhosts.KeyError); ok && len(keyErr.Want) == 0 {
+			return k.add(hostname, key)
+		}
+		return err
+	}
+}
+
+// Fingerprints accepts a key only if its fingerprint is one of fingerprints,
+// usually read from the console log of a new server. The key replaces any
+// key stored for the host before, addresses are reused by new servers.
+func (k *KnownHosts) Fingerprints(fingerprints []string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		sha256 := ssh.FingerprintSHA256(key)
+		md5 := ssh.FingerprintLegacyMD5(key)
+		for _, fingerprint := range fingerprints {
+			if fingerprint == sha256 || strings.TrimPrefix(fingerprint, "MD5:") == md5 {
+				k.mu.Lock()
+				defer k.mu.Unlock()
+				return k.replace(hostname, key)
+			}
+		}
+		return fmt.Errorf("Host key %s of %s doesn't match the console log fingerprints", sha256, hostname)
+	}
+}
+
+// callback reads the store and those of files that exist
+func (k *KnownHosts) callback(files []string) (ssh.HostKeyCallback, error) {
+	paths := []string{k.path}
+	for _, file := range files {
+		if _, err := os.Stat(file); err == nil {
+			paths = append(paths, file)
+		}
+	}
+	return knownhosts.New(paths...)
+}
+
+func (k *KnownHosts) add(hostname string, key ssh.PublicKey) error {
+	f, err := os.OpenFile(k.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key) + "\n")
+	return err
+}
+
+func (k *KnownHosts) replace(hostname string, key ssh.PublicKey) error {
+	data, err := ioutil.ReadFile(k.path)
+	if err != nil {
+		return err
+	}
+
+	host := knownhosts.Normalize(hostname)
+	var buf bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && fields[0] == host {
+			continue
+		}
+		buf.WriteString(scanner.Text() + "\n")
+	}
+	buf.WriteString(knownhosts.Line([]string{host}, key) + "\n")
+
+	return ioutil.WriteFile(k.path, buf.Bytes(), 0600)
+}
+
+// ConsoleFingerprints returns host key fingerprints printed by cloud-init to
+// the console. Fingerprints of printed public keys are added as well.
+func ConsoleFingerprints(console string) ([]string, error) {
+	var fingerprints []string
+	section := ""
+	scanner := bufio.NewScanner(strings.NewReader(console))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.Contains(line, "-----BEGIN SSH HOST KEY FINGERPRINTS-----"):
+			section = "fingerprints"
+			continue
+		case strings.Contains(line, "-----BEGIN SSH HOST KEY KEYS-----"):
+			section = "keys"
+			continue
+		case strings.Contains(line, "-----END SSH HOST KEY"):
+			section = ""
+			continue
+		}
+
+		switch section {
+		case "fingerprints":
+			if m := consoleFingerprint.FindStringSubmatch(line); m != nil {
+				fingerprints = append(fingerprints, m[1])
+			}
+		case "keys":
+			if m := consoleKey.FindString(line); m != "" {
+				key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(m))
+				if err == nil {
+					fingerprints = append(fingerprints, ssh.FingerprintSHA256(key))
+				}
+			}
+		}
+	}
+	if len(fingerprints) == 0 {
+		return nil, errors.New("No SSH host key fingerprints in the console log")
+	}
+	return fingerprints, nil
+}
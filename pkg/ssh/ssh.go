@@ -1,58 +1,95 @@
 package ssh
 
 import (
-	"bytes"
+	"errors"
 	"github.com/foxdalas/nodeup/pkg/nodeup_const"
-	"github.com/pkg/sftp"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/agent"
-	"io"
-	"net"
-	"os"
-	"os/exec"
+	"time"
 )
 
-func New(nodeup nodeup.NodeUP, address string, user string) (*Ssh, error) {
+// New connects to address, through the jump hosts if any are given
+func New(nodeup nodeup.NodeUP, address string, user string, auth *Auth, hostKeyCallback ssh.HostKeyCallback, jumps []Jump) (*Ssh, error) {
 	s := &Ssh{
-		nodeup: nodeup,
-		client: nil,
+		nodeup:  nodeup,
+		client:  nil,
+		auth:    auth,
+		address: address + ":22",
+		route:   jumps,
 	}
 
-	if len(os.Getenv("SSH_AUTH_SOCK")) == 0 {
-		cmd := exec.Command("ssh-agent")
-		err := cmd.Run()
-		if err != nil {
-			return s, err
-		}
+	methods := auth.Methods()
+	if len(methods) == 0 {
+		return s, errors.New("No SSH authentication method, set SSH_AUTH_SOCK, -sshKey or NODEUP_SSH_PASSWORD")
 	}
 
-	socket := os.Getenv("SSH_AUTH_SOCK")
-
-	conn, err := net.Dial("unix", socket)
-	if err != nil {
-		return s, err
+	s.config = &ssh.ClientConfig{
+		User:            user,
+		Auth:            methods,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         dialTimeout,
 	}
-	s.Log().Debugf("Using SSH Agent with socket %s", socket)
 
-	agentClient := agent.NewClient(conn)
+	err := s.connect()
+	return s, err
+}
 
-	sshConfig := &ssh.ClientConfig{
-		User: user,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeysCallback(agentClient.Signers),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-	}
+// connect dials the host and starts the keepalives
+func (s *Ssh) connect() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	client, err := ssh.Dial("tcp", address+":22", sshConfig)
+	client, err := s.dial(s.address, s.config, s.route)
 	if err != nil {
-		return s, err
+		s.close()
+		return err
 	}
 
 	s.client = client
+	s.stop = make(chan struct{})
+	go s.keepalive(client, s.stop)
+	return nil
+}
 
-	return s, err
+// reconnect replaces a dropped connection
+func (s *Ssh) reconnect() error {
+	s.Close()
+	s.Log().Infof("Reconnecting to %s", s.address)
+	return s.connect()
+}
+
+// keepalive sends SSH keepalives and closes the connection when the host
+// stops answering, so commands waiting on it fail instead of hanging
+func (s *Ssh) keepalive(client *ssh.Client, stop chan struct{}) {
+	ticker := time.NewTicker(keepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		reply := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			reply <- err
+		}()
+
+		var err error
+		select {
+		case <-stop:
+			return
+		case err = <-reply:
+		case <-time.After(keepaliveInterval):
+			err = errors.New("no reply")
+		}
+		if err != nil {
+			s.Log().Warnf("SSH keepalive to %s failed, closing the connection: %s", s.address, err)
+			client.Close()
+			return
+		}
+	}
 }
 
 func (o *Ssh) Log() *logrus.Entry {
@@ -60,80 +97,51 @@ func (o *Ssh) Log() *logrus.Entry {
 	return log
 }
 
-func (s *Ssh) sshSession() (*ssh.Session, error) {
-	session, err := s.client.NewSession()
-	if err != nil {
-		return session, err
-	}
-	return session, err
+// Close closes the SSH connection and the jump host connections. It may be
+// called while a command is running to end it.
+func (s *Ssh) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.close()
 }
 
-func (s *Ssh) RunCommand(command string) error {
-	session, err := s.sshSession()
-	if err != nil {
-		s.Log().Errorf("session error: %s", err)
+func (s *Ssh) close() error {
+	var err error
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
 	}
-	defer session.Close()
-
-	var b bytes.Buffer
-	session.Stdout = &b
-	s.Log().Debugf("Running %s", command)
-	if err := session.Run(command); err != nil {
-		s.Log().Errorf("command error: %s", err)
-		return err
+	if s.client != nil {
+		err = s.client.Close()
+		s.client = nil
 	}
-
-	s.Log().Debugf("Finished %s", command)
-
-	return nil
+	for i := len(s.jumps) - 1; i >= 0; i-- {
+		s.jumps[i].Close()
+	}
+	s.jumps = nil
+	return err
 }
 
-func (s *Ssh) RunCommandPipe(command string, outfile *os.File) error {
+// conn returns the connection, nil once it is closed
+func (s *Ssh) conn() *ssh.Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.client
+}
 
-	session, err := s.sshSession()
+// Output runs command and returns its stdout
+func (s *Ssh) Output(command string) ([]byte, error) {
+	client := s.conn()
+	if client == nil {
+		return nil, errors.New("Not connected to " + s.address)
+	}
+	session, err := client.NewSession()
 	if err != nil {
 		s.Log().Errorf("session error: %s", err)
+		return nil, err
 	}
 	defer session.Close()
 
-	s.Log().Debugf("Writing bootstrap output to file %s", outfile.Name())
-
-	session.Stdout = io.MultiWriter(outfile)
-	session.Stderr = session.Stdout
-
 	s.Log().Debugf("Running %s", command)
-	if err := session.Run(command); err != nil {
-		s.Log().Errorf("chef-client error: %s", err)
-		return err
-	}
-
-	s.Log().Debugf("Finished %s", command)
-
-	return nil
-}
-
-func (s *Ssh) TransferFile(data []byte, name string, path string) error {
-	s.Log().Debugf("Starting transferring file %s", path+"/"+name)
-
-	sftp, err := sftp.NewClient(s.client)
-	s.assertError(err)
-
-	w := sftp.Walk(path)
-	for w.Step() {
-		if w.Err() != nil {
-			return err
-		}
-	}
-	f, err := sftp.Create(name)
-	s.assertError(err)
-
-	_, err = f.Write(data)
-	s.assertError(err)
-
-	_, err = sftp.Lstat(name)
-	s.assertError(err)
-
-	s.Log().Debugf("Finished transferring file %s", path+"/"+name)
-
-	return err
+	return session.Output(command)
 }
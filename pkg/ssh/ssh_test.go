@@ -0,0 +1,164 @@
+package ssh
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIP9pesIzyAYXqinbjqVoHJIKPTWRR+pFu8f+diKCKgGD root@test"
+
+func TestConsoleFingerprints(t *testing.T) {
+	console := `[   12.345678] cloud-init[1234]: Cloud-init v. 18.2 finished
+<14>Oct 17 10:00:00 ec2: 
+<14>Oct 17 10:00:00 ec2: #############################################################
+<14>Oct 17 10:00:00 ec2: -----BEGIN SSH HOST KEY FINGERPRINTS-----
+<14>Oct 17 10:00:00 ec2: 256 SHA256:mn8hkzC8R1i4WAYSUy1naw/ty1hJSqPFAANkfaq3Ga8 root@test (ED25519)
+<14>Oct 17 10:00:00 ec2: 2048 3f:2a:9c:1d:55:10:aa:bb:cc:dd:ee:ff:00:11:22:33 root@test (RSA)
+<14>Oct 17 10:00:00 ec2: -----END SSH HOST KEY FINGERPRINTS-----
+-----BEGIN SSH HOST KEY KEYS-----
+` + testKey + `
+-----END SSH HOST KEY KEYS-----
+`
+	fingerprints, err := ConsoleFingerprints(console)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{
+		"SHA256:mn8hkzC8R1i4WAYSUy1naw/ty1hJSqPFAANkfaq3Ga8",
+		"3f:2a:9c:1d:55:10:aa:bb:cc:dd:ee:ff:00:11:22:33",
+		"SHA256:mn8hkzC8R1i4WAYSUy1naw/ty1hJSqPFAANkfaq3Ga8",
+	}, fingerprints)
+
+	_, err = ConsoleFingerprints("login:")
+	assert.NotNil(t, err)
+}
+
+func TestKnownHosts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodeup-known-hosts")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	k, err := NewKnownHosts(filepath.Join(dir, "nodeup", "known_hosts"))
+	assert.Equal(t, nil, err)
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(testKey))
+	assert.Equal(t, nil, err)
+	addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+
+	assert.NotNil(t, k.Strict()("10.0.0.1:22", addr, key))
+	assert.NotNil(t, k.Fingerprints([]string{"SHA256:other"})("10.0.0.1:22", addr, key))
+	assert.Equal(t, nil, k.Fingerprints([]string{"SHA256:mn8hkzC8R1i4WAYSUy1naw/ty1hJSqPFAANkfaq3Ga8"})("10.0.0.1:22", addr, key))
+	assert.Equal(t, nil, k.Strict()("10.0.0.1:22", addr, key))
+
+	assert.Equal(t, nil, k.TrustOnFirstUse()("10.0.0.2:22", addr, key))
+	assert.Equal(t, nil, k.Strict()("10.0.0.2:22", addr, key))
+}
+
+func TestNewAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodeup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	block, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key), []byte("secret"), x509.PEMCipherAES256)
+	assert.NoError(t, err)
+	keyPath := filepath.Join(dir, "id_rsa")
+	assert.NoError(t, ioutil.WriteFile(keyPath, pem.EncodeToMemory(block), 0600))
+
+	signer, err := ssh.NewSignerFromKey(key)
+	assert.NoError(t, err)
+	cert := &ssh.Certificate{
+		Key:             signer.PublicKey(),
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"cloud-user"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	assert.NoError(t, cert.SignCert(rand.Reader, signer))
+	assert.NoError(t, ioutil.WriteFile(keyPath+"-cert.pub", ssh.MarshalAuthorizedKey(cert), 0644))
+
+	os.Unsetenv("SSH_AUTH_SOCK")
+
+	_, err = NewAuth([]string{AuthKey}, keyPath, "", "", "")
+	assert.Error(t, err)
+	_, err = NewAuth([]string{AuthKey, "gssapi"}, "", "", "", "")
+	assert.Error(t, err)
+
+	auth, err := NewAuth([]string{AuthAgent, AuthPassword, AuthKey, AuthCert}, keyPath, "", "secret", "rescue")
+	assert.NoError(t, err)
+	assert.Len(t, auth.Methods(), 3)
+
+	signers, err := auth.signers()
+	assert.NoError(t, err)
+	assert.Len(t, signers, 2)
+	assert.Equal(t, ssh.KeyAlgoRSA, signers[0].PublicKey().Type())
+	assert.Equal(t, ssh.CertAlgoRSAv01, signers[1].PublicKey().Type())
+
+	auth, err = NewAuth([]string{AuthAgent}, "", "", "", "rescue")
+	assert.NoError(t, err)
+	assert.Empty(t, auth.Methods())
+}
+
+func TestExecutorTimeout(t *testing.T) {
+	e := NewExecutor(&Ssh{}, 10*time.Minute, 0)
+	timeout, err := e.timeout()
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, timeout)
+
+	e = NewExecutor(&Ssh{}, 10*time.Minute, time.Minute)
+	timeout, err = e.timeout()
+	assert.NoError(t, err)
+	assert.True(t, timeout <= time.Minute && timeout > 0)
+
+	e.deadline = time.Now().Add(-time.Second)
+	_, err = e.timeout()
+	assert.Error(t, err)
+}
+
+func TestResultError(t *testing.T) {
+	tail := &tailBuffer{limit: 16}
+	tail.Write([]byte("Reading package lists...\n"))
+	tail.Write([]byte("E: Unable to locate package\n"))
+	assert.Equal(t, "locate package\n", tail.String()[len(tail.String())-15:])
+	assert.Len(t, tail.String(), 16)
+
+	result := &Result{ExitCode: 100, Stderr: "W: warning\nE: Unable to lock\n"}
+	assert.EqualError(t, result.error(), "Exit code 100: E: Unable to lock")
+	result = &Result{ExitCode: 1}
+	assert.EqualError(t, result.error(), "Exit code 1")
+}
+
+func TestUpload(t *testing.T) {
+	file := &Upload{Path: "/etc/nginx/ssl/it's.pem", Mode: 0640, Owner: "root:www-data", Sudo: true}
+	assert.Equal(t, `sudo install -D -m 0640 -o 'root' -g 'www-data' '/home/cloud-user/.nodeup-upload-0' '/etc/nginx/ssl/it'\''s.pem' && rm -f '/home/cloud-user/.nodeup-upload-0'`,
+		installCommand("/home/cloud-user/.nodeup-upload-0", file))
+
+	out := []byte("2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae  /etc/foo\n" +
+		"fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9  /home/cloud-user/bar baz\n")
+	assert.Equal(t, map[string]string{
+		"/etc/foo":                 "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+		"/home/cloud-user/bar baz": "fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9",
+	}, parseChecksums(out))
+
+	dir, err := ioutil.TempDir("", "nodeup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "conf.d"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "conf.d", "app.conf"), []byte("app"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "run.sh"), []byte("run"), 0755))
+
+	files, err := Tree(dir, "/etc/app")
+	assert.NoError(t, err)
+	assert.Equal(t, []*Upload{
+		{Path: "/etc/app/conf.d/app.conf", Data: []byte("app"), Mode: 0644},
+		{Path: "/etc/app/run.sh", Data: []byte("run"), Mode: 0755},
+	}, files)
+}
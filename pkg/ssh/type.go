@@ -4,11 +4,80 @@ import (
 	"github.com/foxdalas/nodeup/pkg/nodeup_const"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	dialTimeout       = 10 * time.Second
+	keepaliveInterval = 30 * time.Second
+	stderrLimit       = 4096
 )
 
 type Ssh struct {
-	nodeup nodeup.NodeUP
-	client *ssh.Client
+	nodeup  nodeup.NodeUP
+	client  *ssh.Client
+	jumps   []*ssh.Client
+	auth    *Auth
+	address string
+	config  *ssh.ClientConfig
+	route   []Jump
+	stop    chan struct{}
+	mu      sync.Mutex
 
 	log *logrus.Entry
 }
+
+// Executor runs commands on a host with a timeout per command and a deadline
+// for all of them. A dropped connection is dialed again for the next command.
+type Executor struct {
+	ssh            *Ssh
+	commandTimeout time.Duration
+	deadline       time.Time
+	mu             sync.Mutex
+}
+
+// Result is a command run by the Executor. ExitCode is -1 when the command
+// didn't finish.
+type Result struct {
+	Command  string
+	ExitCode int
+	Duration time.Duration
+	Stderr   string
+}
+
+// Upload is a file to put on a host at the absolute Path. Sudo files are
+// installed as root and owned by Owner (user or user:group).
+type Upload struct {
+	Path  string
+	Data  []byte
+	Mode  os.FileMode
+	Owner string
+	Sudo  bool
+}
+
+// KnownHosts is the nodeup known_hosts file
+type KnownHosts struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Auth is the SSH authentication of nodeup
+type Auth struct {
+	order    []string
+	agent    agent.Agent
+	key      ssh.Signer
+	cert     ssh.Signer
+	password string
+}
+
+// Jump is a jump host (ProxyJump) on the way to the target. Without Auth the
+// authentication of the target is used. HostKeyCallback is required.
+type Jump struct {
+	Address         string
+	User            string
+	Auth            *Auth
+	HostKeyCallback ssh.HostKeyCallback
+}
@@ -0,0 +1,189 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Upload sends files over one SFTP session. Sudo files are written to tmpDir
+// first and moved into place with sudo install. All checksums are verified
+// afterwards.
+func (e *Executor) Upload(files []*Upload, tmpDir string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	client, err := e.sftp()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var installs []string
+	sudo := false
+	for i, file := range files {
+		if !path.IsAbs(file.Path) {
+			return errors.New("Upload path " + file.Path + " is not absolute")
+		}
+
+		dest, mode := file.Path, file.Mode
+		if file.Sudo {
+			dest, mode = path.Join(tmpDir, ".nodeup-upload-"+strconv.Itoa(i)), 0600
+			installs = append(installs, installCommand(dest, file))
+			sudo = true
+		}
+
+		e.ssh.Log().Debugf("Uploading %s", file.Path)
+		err = writeFile(client, dest, file.Data, mode)
+		if err != nil {
+			return fmt.Errorf("Upload %s: %s", file.Path, err)
+		}
+	}
+
+	if len(installs) > 0 {
+		_, err = e.exec(strings.Join(installs, " && "), nil, nil)
+		if err != nil {
+			return fmt.Errorf("Upload install: %s", err)
+		}
+	}
+	return e.verify(files, sudo)
+}
+
+// sftp opens an SFTP session, dialing the host again if the connection is gone
+func (e *Executor) sftp() (*sftp.Client, error) {
+	if conn := e.ssh.conn(); conn != nil {
+		client, err := sftp.NewClient(conn)
+		if err == nil {
+			return client, nil
+		}
+		e.ssh.Log().Warnf("SFTP session to %s failed: %s", e.ssh.address, err)
+	}
+
+	err := e.ssh.reconnect()
+	if err != nil {
+		return nil, err
+	}
+	conn := e.ssh.conn()
+	if conn == nil {
+		return nil, errors.New("Not connected to " + e.ssh.address)
+	}
+	return sftp.NewClient(conn)
+}
+
+func writeFile(client *sftp.Client, name string, data []byte, mode os.FileMode) error {
+	err := client.MkdirAll(path.Dir(name))
+	if err != nil {
+		return err
+	}
+
+	f, err := client.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	// set the mode before writing, secrets are never readable by others
+	err = f.Chmod(mode)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func installCommand(tmp string, file *Upload) string {
+	command := "sudo install -D -m " + fmt.Sprintf("%04o", file.Mode)
+	if file.Owner != "" {
+		owner := strings.SplitN(file.Owner, ":", 2)
+		command += " -o " + quote(owner[0])
+		if len(owner) > 1 {
+			command += " -g " + quote(owner[1])
+		}
+	}
+	return command + " " + quote(tmp) + " " + quote(file.Path) + " && rm -f " + quote(tmp)
+}
+
+// verify compares the sha256 of the uploaded files on the host
+func (e *Executor) verify(files []*Upload, sudo bool) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	command := "sha256sum --"
+	if sudo {
+		command = "sudo " + command
+	}
+	for _, file := range files {
+		command += " " + quote(file.Path)
+	}
+
+	var out bytes.Buffer
+	_, err := e.exec(command, &out, nil)
+	if err != nil {
+		return fmt.Errorf("Upload checksum: %s", err)
+	}
+
+	sums := parseChecksums(out.Bytes())
+	for _, file := range files {
+		sum := sha256.Sum256(file.Data)
+		if sums[file.Path] != hex.EncodeToString(sum[:]) {
+			return errors.New("Upload checksum mismatch for " + file.Path)
+		}
+	}
+	e.ssh.Log().Debugf("Verified %d uploaded files", len(files))
+	return nil
+}
+
+// parseChecksums reads sha256sum output into checksums by path
+func parseChecksums(out []byte) map[string]string {
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "  ", 2)
+		if len(fields) == 2 {
+			sums[fields[1]] = fields[0]
+		}
+	}
+	return sums
+}
+
+// Tree returns uploads for every file in the local directory dir, placed
+// under the remote directory with the same relative paths and modes
+func Tree(dir string, remote string) ([]*Upload, error) {
+	var files []*Upload
+	err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		files = append(files, &Upload{
+			Path: path.Join(remote, filepath.ToSlash(rel)),
+			Data: data,
+			Mode: info.Mode().Perm(),
+		})
+		return nil
+	})
+	return files, err
+}
+
+// quote quotes s for the remote shell
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -1,7 +0,0 @@
-package ssh
-
-func (s *Ssh) assertError(err error) {
-	if err != nil {
-		s.Log().Error(err)
-	}
-}